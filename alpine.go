@@ -0,0 +1,272 @@
+package main
+
+/*
+$ apk list --upgradable
+busybox-1.31.1-r20 x86_64 {busybox} (GPL-2.0-only) [upgradable from: busybox-1.31.1-r19]
+musl-1.1.24-r9 x86_64 {musl} (MIT) [upgradable from: musl-1.1.24-r8]
+
+$ apk version -l '<'
+Installed:                                Available:
+busybox-1.31.1-r19                      < 1.31.1-r20
+musl-1.1.24-r8                          < 1.1.24-r9
+
+$ apk policy busybox
+busybox policy:
+  1.31.1-r20:
+    http://dl-cdn.alpinelinux.org/alpine/v3.12/main
+  1.31.1-r19:
+    lib/apk/db/installed
+
+$ cat /var/log/apk.log
+(1/2) Upgrading busybox (1.31.1-r19 -> 1.31.1-r20)
+(2/2) Purging foo (1.0-r0)
+*/
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cosandr/go-check-updates/api"
+)
+
+// Group 1: <name>-<new version>
+// Group 2: arch
+// Group 3: origin
+// Group 4: <name>-<old version>
+var reApkList = regexp.MustCompile(`(?m)^(\S+)\s+(\S+)\s+\{(\S+)\}\s+\(.*\)\s+\[upgradable from:\s+(\S+)\]\s*$`)
+
+// Group 1: <name>-<old version>
+// Group 2: new version
+var reApkVersion = regexp.MustCompile(`(?m)^(\S+)\s+<\s+(\S+)\s*$`)
+
+// Group 1: name
+// Group 2: version, apk versions always end with -r<N>
+var reApkPkgVer = regexp.MustCompile(`^(.+)-([^-]+-r\d+)$`)
+
+// Group 1: action (Installing, Upgrading, Downgrading, Purging, etc.)
+// Group 2: name
+// Group 3: version, "<old> -> <new>" if upgraded
+var reApkLog = regexp.MustCompile(`\(\d+/\d+\)\s+(\w+)\s+(\S+)\s+\((.*)\)`)
+
+// apkBackend checks for updates using apk, the package index must be refreshed externally
+type apkBackend struct {
+	logFp       string
+	installedFp string
+}
+
+func init() {
+	registerBackend(&apkBackend{
+		logFp:       "/var/log/apk.log",
+		installedFp: "/lib/apk/db/installed",
+	})
+}
+
+func (b *apkBackend) Name() string { return "apk" }
+
+func (b *apkBackend) Detect(ctx context.Context, distro string) bool { return distro == "alpine" }
+
+func (b *apkBackend) Check(ctx context.Context) (api.UpdatesList, error) { return UpdateApk(ctx) }
+
+// LogPaths returns the apk log, if present, and the installed packages database
+func (b *apkBackend) LogPaths() []string {
+	ret := make([]string, 0)
+	for _, fp := range []string{b.logFp, b.installedFp} {
+		if fp != "" && checkFileExists(fp) {
+			ret = append(ret, fp)
+		}
+	}
+	return ret
+}
+
+func (b *apkBackend) ParseLog(ctx context.Context, fp string, f *api.File) error {
+	if fp == b.installedFp {
+		return checkApkInstalled(fp, f)
+	}
+	return checkApkLogs(fp, f)
+}
+
+func (b *apkBackend) Capabilities() Capability { return CapOldVersion | CapRepo | CapLogs }
+
+// UpdateApk uses apk to list upgradable packages, repositories are looked up with apk policy
+func UpdateApk(ctx context.Context) (api.UpdatesList, error) {
+	var updates api.UpdatesList
+	raw, err := runCmd(ctx, "apk", "list", "--upgradable")
+	if err == nil {
+		updates = parseApkList(raw)
+	} else {
+		// Older apk-tools don't have list
+		log.Debugf("UpdateApk: apk list failed, trying apk version: %v", err)
+		raw, err = runCmd(ctx, "apk", "version", "-l", "<")
+		if err != nil {
+			return api.UpdatesList{}, err
+		}
+		updates = parseApkVersion(raw)
+	}
+	if len(updates) == 0 {
+		return updates, nil
+	}
+	names := make([]string, len(updates))
+	for i, u := range updates {
+		names[i] = u.Pkg
+	}
+	raw, err = runCmd(ctx, "apk", append([]string{"policy"}, names...)...)
+	if err != nil {
+		log.Warnf("UpdateApk: cannot get repositories: %v", err)
+		return updates, nil
+	}
+	repos := parseApkPolicy(raw)
+	for i, u := range updates {
+		if url, ok := repos[u.Pkg][u.NewVer]; ok {
+			updates[i].Repo = path.Base(url)
+		}
+	}
+	return updates, nil
+}
+
+// splitApkPkgVer splits <name>-<version>-r<N> into name and version
+func splitApkPkgVer(s string) (name string, ver string) {
+	m := reApkPkgVer.FindStringSubmatch(s)
+	if m == nil {
+		return s, ""
+	}
+	return m[1], m[2]
+}
+
+func parseApkList(out string) api.UpdatesList {
+	updates := make(api.UpdatesList, 0)
+	for _, m := range reApkList.FindAllStringSubmatch(out, -1) {
+		name, newVer := splitApkPkgVer(m[1])
+		_, oldVer := splitApkPkgVer(m[4])
+		updates = append(updates, api.Update{
+			Pkg:    name,
+			OldVer: oldVer,
+			NewVer: newVer,
+			Arch:   m[2],
+		})
+	}
+	return updates
+}
+
+func parseApkVersion(out string) api.UpdatesList {
+	updates := make(api.UpdatesList, 0)
+	for _, m := range reApkVersion.FindAllStringSubmatch(out, -1) {
+		name, oldVer := splitApkPkgVer(m[1])
+		updates = append(updates, api.Update{
+			Pkg:    name,
+			OldVer: oldVer,
+			NewVer: m[2],
+		})
+	}
+	return updates
+}
+
+// parseApkPolicy returns a map of package name -> version -> repository
+func parseApkPolicy(out string) map[string]map[string]string {
+	ret := make(map[string]map[string]string)
+	var name, ver string
+	for _, line := range strings.Split(out, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "":
+			continue
+		case strings.HasSuffix(trimmed, " policy:"):
+			name = strings.TrimSuffix(trimmed, " policy:")
+			ret[name] = make(map[string]string)
+		case strings.HasSuffix(trimmed, ":") && name != "":
+			ver = strings.TrimSuffix(trimmed, ":")
+		case ver != "" && name != "":
+			// First repository listed is the preferred one
+			if _, ok := ret[name][ver]; !ok {
+				ret[name][ver] = trimmed
+			}
+		}
+	}
+	return ret
+}
+
+// checkApkLogs read apk log file and update f accordingly
+//
+// The log has no timestamps, upgrades are matched by version instead. Removals are ignored
+// since they may predate a reinstall, checkApkInstalled handles uninstalled packages.
+func checkApkLogs(fp string, f *api.File) error {
+	file, err := os.Open(fp)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	beforeLen := len(f.Updates)
+	for scanner.Scan() {
+		m := reApkLog.FindStringSubmatch(scanner.Text())
+		if len(m) != 4 {
+			continue
+		}
+		action, name, ver := m[1], m[2], m[3]
+		switch action {
+		case "Upgrading", "Downgrading", "Replacing":
+			tmp := strings.Split(ver, " -> ")
+			if len(tmp) != 2 {
+				log.Warnf("checkApkLogs: expected 'old -> new', got '%s'", ver)
+				continue
+			}
+			if changed := f.Remove(name, tmp[1]); changed {
+				log.Debugf("checkApkLogs: removed upgraded package %s %s", name, tmp[1])
+			}
+		}
+	}
+	if len(f.Updates) != beforeLen {
+		log.Infof("checkApkLogs: %s: removed %d pending updates", fp, beforeLen-len(f.Updates))
+	}
+	return scanner.Err()
+}
+
+// checkApkInstalled read apk installed database and remove updates which are installed or no longer present
+func checkApkInstalled(fp string, f *api.File) error {
+	file, err := os.Open(fp)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	installed := make(map[string]string)
+	var name string
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			name = ""
+		case strings.HasPrefix(line, "P:"):
+			name = line[2:]
+		case strings.HasPrefix(line, "V:") && name != "":
+			installed[name] = line[2:]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	beforeLen := len(f.Updates)
+	updates := make(api.UpdatesList, 0)
+	for _, u := range f.Updates {
+		ver, ok := installed[u.Pkg]
+		if u.Backend != "" && u.Backend != "apk" {
+			updates = append(updates, u)
+		} else if !ok {
+			log.Debugf("checkApkInstalled: removed uninstalled package %s", u.Pkg)
+		} else if ver == u.NewVer {
+			log.Debugf("checkApkInstalled: removed upgraded package %s %s", u.Pkg, ver)
+		} else {
+			updates = append(updates, u)
+		}
+	}
+	f.Updates = updates
+	if len(f.Updates) != beforeLen {
+		log.Infof("checkApkInstalled: %s: removed %d pending updates", fp, beforeLen-len(f.Updates))
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"io/ioutil"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cosandr/go-check-updates/api"
+)
+
+func checkAlpineParse(t *testing.T, expected api.UpdatesList, actual api.UpdatesList) {
+	if len(actual) != len(expected) {
+		t.Fatalf("expected %d updates, got %d", len(expected), len(actual))
+	}
+	for i, a := range actual {
+		if e := expected[i]; !a.Equals(&e) {
+			t.Errorf("expected %v, got %v", e, a)
+		}
+	}
+}
+
+func TestAlpineParseApkList(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+	out := `busybox-1.31.1-r20 x86_64 {busybox} (GPL-2.0-only) [upgradable from: busybox-1.31.1-r19]
+libcrypto1.1-1.1.1i-r0 x86_64 {openssl} (OpenSSL) [upgradable from: libcrypto1.1-1.1.1g-r0]
+musl-1.1.24-r9 x86_64 {musl} (MIT) [upgradable from: musl-1.1.24-r8]
+ssl_client-1.31.1-r20 x86_64 {busybox} (GPL-2.0-only) [upgradable from: ssl_client-1.31.1-r19]
+`
+	expected := api.UpdatesList{
+		{
+			Pkg:    "busybox",
+			OldVer: "1.31.1-r19",
+			NewVer: "1.31.1-r20",
+			Arch:   "x86_64",
+		},
+		{
+			Pkg:    "libcrypto1.1",
+			OldVer: "1.1.1g-r0",
+			NewVer: "1.1.1i-r0",
+			Arch:   "x86_64",
+		},
+		{
+			Pkg:    "musl",
+			OldVer: "1.1.24-r8",
+			NewVer: "1.1.24-r9",
+			Arch:   "x86_64",
+		},
+		{
+			Pkg:    "ssl_client",
+			OldVer: "1.31.1-r19",
+			NewVer: "1.31.1-r20",
+			Arch:   "x86_64",
+		},
+	}
+	checkAlpineParse(t, expected, parseApkList(out))
+	// apk version does not show the architecture
+	for i := range expected {
+		expected[i].Arch = ""
+	}
+	out = `Installed:                                Available:
+busybox-1.31.1-r19                      < 1.31.1-r20
+libcrypto1.1-1.1.1g-r0                  < 1.1.1i-r0
+musl-1.1.24-r8                          < 1.1.24-r9
+ssl_client-1.31.1-r19                   < 1.31.1-r20
+`
+	checkAlpineParse(t, expected, parseApkVersion(out))
+}
+
+func TestAlpineParseApkPolicy(t *testing.T) {
+	out := `busybox policy:
+  1.31.1-r20:
+    http://dl-cdn.alpinelinux.org/alpine/v3.12/main
+    http://mirror.example.com/alpine/v3.12/main
+  1.31.1-r19:
+    lib/apk/db/installed
+py3-pip policy:
+  20.1.1-r0:
+    lib/apk/db/installed
+  20.3.1-r0:
+    http://dl-cdn.alpinelinux.org/alpine/v3.12/community
+`
+	actual := parseApkPolicy(out)
+	for name, vers := range map[string]map[string]string{
+		"busybox": {
+			"1.31.1-r20": "http://dl-cdn.alpinelinux.org/alpine/v3.12/main",
+			"1.31.1-r19": "lib/apk/db/installed",
+		},
+		"py3-pip": {
+			"20.1.1-r0": "lib/apk/db/installed",
+			"20.3.1-r0": "http://dl-cdn.alpinelinux.org/alpine/v3.12/community",
+		},
+	} {
+		for ver, repo := range vers {
+			if actual[name][ver] != repo {
+				t.Errorf("expected %s %s from %s, got %s", name, ver, repo, actual[name][ver])
+			}
+		}
+	}
+}
+
+func TestAlpineApkLogs(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+	cache = NewInternalCache()
+	allUpdates := api.UpdatesList{
+		{
+			Pkg:    "busybox",
+			NewVer: "1.31.1-r20",
+		},
+		{
+			Pkg:    "musl",
+			NewVer: "1.1.24-r9",
+		},
+		{
+			Pkg:    "ssl_client",
+			NewVer: "1.31.1-r20",
+		},
+	}
+	file := `
+(1/3) Upgrading musl (1.1.24-r8 -> 1.1.24-r9)
+(2/3) Upgrading busybox (1.31.1-r19 -> 1.31.1-r21)
+(3/3) Purging ssl_client (1.31.1-r19)
+Executing busybox-1.31.1-r21.trigger
+OK: 8 MiB in 14 packages
+`
+	cache.f.Updates = allUpdates.Copy()
+	fp := "/tmp/apk_test.log"
+	if err := ioutil.WriteFile(fp, []byte(file), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkApkLogs(fp, &cache.f); err != nil {
+		t.Fatal(err)
+	}
+	// Purged packages may have been installed again since, left to checkApkInstalled
+	if len(cache.f.Updates) != 2 || cache.f.Updates[0].Pkg != "busybox" || cache.f.Updates[1].Pkg != "ssl_client" {
+		t.Errorf("Expected only busybox and ssl_client, got %d: %v", len(cache.f.Updates), cache.f.Updates)
+	}
+}
+
+func TestAlpineApkInstalled(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+	cache = NewInternalCache()
+	cache.f.Updates = api.UpdatesList{
+		{
+			Pkg:     "busybox",
+			NewVer:  "1.31.1-r20",
+			Backend: "apk",
+		},
+		{
+			Pkg:     "musl",
+			NewVer:  "1.1.24-r9",
+			Backend: "apk",
+		},
+		{
+			Pkg:     "ssl_client",
+			NewVer:  "1.31.1-r20",
+			Backend: "apk",
+		},
+		{
+			Pkg:     "org.gimp.GIMP",
+			NewVer:  "2.10.22",
+			Backend: "flatpak",
+		},
+	}
+	file := `C:Q1Jn8hoRLoNavjU5HbETh6BMqKFaM=
+P:musl
+V:1.1.24-r9
+A:x86_64
+S:376779
+I:614400
+T:the musl c library (libc) implementation
+
+C:Q1lyDRy5LYDzP2qcmRS6LRqN5dclI=
+P:busybox
+V:1.31.1-r19
+A:x86_64
+S:500000
+I:940000
+T:Size optimized toolbox of many common UNIX utilities
+`
+	fp := "/tmp/apk_installed_test"
+	if err := ioutil.WriteFile(fp, []byte(file), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkApkInstalled(fp, &cache.f); err != nil {
+		t.Fatal(err)
+	}
+	// musl upgraded, ssl_client removed, flatpak untouched
+	if len(cache.f.Updates) != 2 || cache.f.Updates[0].Pkg != "busybox" || cache.f.Updates[1].Pkg != "org.gimp.GIMP" {
+		t.Errorf("Expected busybox and org.gimp.GIMP, got %d: %v", len(cache.f.Updates), cache.f.Updates)
+	}
+}
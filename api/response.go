@@ -6,4 +6,8 @@ type Response struct {
 	Error    string `json:"error,omitempty"`
 	FilePath string `json:"filePath,omitempty"`
 	Queued   *bool  `json:"queued,omitempty"`
+	// True if the requested refresh joined one which was already in progress
+	Joined *bool `json:"joined,omitempty"`
+	// True if the refresh failed because backends did not finish in time
+	Timeout *bool `json:"timeout,omitempty"`
 }
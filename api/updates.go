@@ -2,13 +2,54 @@ package api
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 )
 
+// Change levels of an update, from largest to smallest
+const (
+	ChangeMajor   = "major"
+	ChangeMinor   = "minor"
+	ChangePatch   = "patch"
+	ChangeRelease = "pkgrel"
+)
+
+// FileVersion is the current version of the File format, files without a version are 0
+const FileVersion = 1
+
+// fileMigrations upgrade a File from the version at their index to the next one
+var fileMigrations = []func(f *File){
+	// 0 -> 1: refresh status added, checked was only set by successful refreshes
+	func(f *File) {
+		if f.LastSuccess == "" && f.ConsecutiveFailures == 0 {
+			f.LastSuccess = f.Checked
+		}
+	},
+}
+
 // File is the struct for the json file
 type File struct {
-	Checked string      `json:"checked"`
-	Updates UpdatesList `json:"updates"`
+	// Format version, see FileVersion
+	Version int               `json:"version"`
+	Checked string            `json:"checked"`
+	Updates UpdatesList       `json:"updates"`
+	Errors  map[string]string `json:"errors,omitempty"` // Backend name -> error message from last check
+	// True if the running kernel or core libraries are outdated
+	RebootRequired bool `json:"rebootRequired"`
+	// Services, or commands if not run by systemd, still using replaced libraries
+	ServicesToRestart []string `json:"servicesToRestart,omitempty"`
+	// True while a refresh is in progress, only set in API responses and websocket messages
+	Refreshing bool `json:"refreshing,omitempty"`
+	// Time the refresh in progress started, in RFC3339 format
+	RefreshStarted string `json:"refreshStarted,omitempty"`
+	// Time of the last refresh without errors, in RFC3339 format
+	LastSuccess string `json:"lastSuccess,omitempty"`
+	// Error of the last refresh, empty if it succeeded
+	LastError string `json:"lastError,omitempty"`
+	// Number of refreshes which failed since the last success
+	ConsecutiveFailures int `json:"consecutiveFailures"`
+	// True if the last success is too old, only set in API responses and websocket messages
+	Stale bool `json:"stale,omitempty"`
 }
 
 // IsEmpty returns True if File is empty
@@ -18,17 +59,58 @@ func (f File) IsEmpty() bool {
 
 // Copy returns a deep copy of this struct
 func (f File) Copy() File {
-	cp := File{Checked: f.Checked}
+	cp := File{
+		Version:        f.Version,
+		Checked:        f.Checked,
+		RebootRequired: f.RebootRequired,
+		Refreshing:     f.Refreshing,
+		RefreshStarted: f.RefreshStarted,
+
+		LastSuccess:         f.LastSuccess,
+		LastError:           f.LastError,
+		ConsecutiveFailures: f.ConsecutiveFailures,
+		Stale:               f.Stale,
+	}
 	cp.Updates = f.Updates.Copy()
+	if f.ServicesToRestart != nil {
+		cp.ServicesToRestart = append([]string(nil), f.ServicesToRestart...)
+	}
+	if f.Errors != nil {
+		cp.Errors = make(map[string]string, len(f.Errors))
+		for k, v := range f.Errors {
+			cp.Errors[k] = v
+		}
+	}
 	return cp
 }
 
+// Migrate upgrades f to the current FileVersion
+//
+// Files with a newer version cannot be migrated, they are returned as an error.
+func (f *File) Migrate() error {
+	if f.Version > FileVersion {
+		return fmt.Errorf("file version %d is newer than supported version %d", f.Version, FileVersion)
+	}
+	for f.Version < FileVersion {
+		fileMigrations[f.Version](f)
+		f.Version++
+	}
+	return nil
+}
+
 // Remove removes update from internal list if name matches
 // If newVer isn't an empty string, only remove if that matches as well
 func (f *File) Remove(name string, newVer string) bool {
+	return f.RemoveArch(name, "", newVer)
+}
+
+// RemoveArch removes update from internal list if name matches
+// If arch or newVer aren't empty strings, only remove if they match as well,
+// updates without an architecture match any
+func (f *File) RemoveArch(name string, arch string, newVer string) bool {
 	updates := make(UpdatesList, 0)
 	for _, u := range f.Updates {
-		if u.Pkg != name || (newVer != "" && u.NewVer != newVer) {
+		if u.Pkg != name || (newVer != "" && u.NewVer != newVer) || (arch != "" && u.Arch != "" && u.Arch != arch) {
 			updates = append(updates, u)
 		}
 	}
@@ -38,11 +120,13 @@ func (f *File) Remove(name string, newVer string) bool {
 }
 
 // RemoveContains removes update from internal list if `check` contains `u.Pkg`
-// If newVer is true, also check if that is present
+// If newVer is true, also check if that is present, updates with an architecture
+// are only removed if `check` ends with .<arch>
 func (f *File) RemoveContains(check string, newVer bool) bool {
 	updates := make(UpdatesList, 0)
 	for _, u := range f.Updates {
-		if !strings.Contains(check, u.Pkg) || (newVer && !strings.Contains(check, u.NewVer)) {
+		if !strings.Contains(check, u.Pkg) || (newVer && !strings.Contains(check, u.NewVer)) ||
+			(u.Arch != "" && !strings.HasSuffix(check, "."+u.Arch)) {
 			updates = append(updates, u)
 		}
 	}
@@ -55,6 +139,9 @@ func (f *File) String() string {
 	ret := fmt.Sprintf("Checked: %s", f.Checked)
 	for _, u := range f.Updates {
 		ret += "\n" + u.Pkg
+		if u.Arch != "" {
+			ret += "." + u.Arch
+		}
 		if u.OldVer != "" {
 			ret += fmt.Sprintf(" %s", u.OldVer)
 		}
@@ -62,6 +149,26 @@ func (f *File) String() string {
 		if u.Repo != "" {
 			ret += fmt.Sprintf(" [%s]", u.Repo)
 		}
+		if u.Advisory != "" {
+			ret += fmt.Sprintf(" (%s)", u.Advisory)
+		}
+		if u.Downgrade {
+			ret += " downgrade"
+		}
+	}
+	names := make([]string, 0, len(f.Errors))
+	for name := range f.Errors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		ret += fmt.Sprintf("\n%s failed: %s", name, f.Errors[name])
+	}
+	if f.RebootRequired {
+		ret += "\nReboot required"
+	}
+	if len(f.ServicesToRestart) > 0 {
+		ret += fmt.Sprintf("\nRestart: %s", strings.Join(f.ServicesToRestart, ", "))
 	}
 	return ret
 }
@@ -83,19 +190,89 @@ func (u *UpdatesList) Contains(other Update) bool {
 func (u *UpdatesList) Copy() UpdatesList {
 	ret := make(UpdatesList, len(*u))
 	copy(ret, *u)
+	for i := range ret {
+		if ret[i].CVEs != nil {
+			ret[i].CVEs = append([]string(nil), ret[i].CVEs...)
+		}
+	}
+	return ret
+}
+
+// FilterChange returns the updates with one of the given change levels
+func (u *UpdatesList) FilterChange(levels []string) UpdatesList {
+	ret := make(UpdatesList, 0)
+	for _, up := range *u {
+		for _, l := range levels {
+			if up.Change == l {
+				ret = append(ret, up)
+				break
+			}
+		}
+	}
+	return ret
+}
+
+// Downgrades returns the updates which install an older version
+func (u *UpdatesList) Downgrades() UpdatesList {
+	ret := make(UpdatesList, 0)
+	for _, up := range *u {
+		if up.Downgrade {
+			ret = append(ret, up)
+		}
+	}
 	return ret
 }
 
+// KindPatch is the kind of updates which are patches rather than packages, e.g. zypper patches
+const KindPatch = "patch"
+
+// Packages returns the updates which are not patches
+func (u *UpdatesList) Packages() UpdatesList {
+	ret := make(UpdatesList, 0, len(*u))
+	for _, up := range *u {
+		if up.Kind != KindPatch {
+			ret = append(ret, up)
+		}
+	}
+	return ret
+}
+
+// DownloadSize returns the total download size in bytes of updates which have one
+func (u *UpdatesList) DownloadSize() int64 {
+	var total int64
+	for _, up := range *u {
+		total += up.DownloadSize
+	}
+	return total
+}
+
 // Update is the struct for pending updates
 type Update struct {
-	Pkg    string `json:"pkg"`
-	OldVer string `json:"oldVer,omitempty"`
-	NewVer string `json:"newVer"`
-	Repo   string `json:"repo,omitempty"`
+	Pkg      string   `json:"pkg"`
+	OldVer   string   `json:"oldVer,omitempty"`
+	NewVer   string   `json:"newVer"`
+	Repo     string   `json:"repo,omitempty"`
+	Backend  string   `json:"backend,omitempty"`
+	Category string   `json:"category,omitempty"` // e.g. security, bugfix, enhancement, recommended, optional
+	Severity string   `json:"severity,omitempty"` // e.g. critical, important, moderate, low
+	Scope    string   `json:"scope,omitempty"`    // system or user, empty if the backend only has one
+	Kind     string   `json:"kind,omitempty"`     // patch, empty for packages
+	Advisory string   `json:"advisory,omitempty"` // e.g. FEDORA-2020-1a2b3c4d5e
+	CVEs     []string `json:"cves,omitempty"`
+	// Computed from OldVer and NewVer, one of major, minor, patch or pkgrel, empty if unknown
+	Change    string `json:"change,omitempty"`
+	Downgrade bool   `json:"downgrade,omitempty"` // True if NewVer is older than OldVer
+	Arch      string `json:"arch,omitempty"`      // e.g. x86_64, noarch, amd64
+	Epoch     string `json:"epoch,omitempty"`     // Epoch of NewVer, empty if unset or zero
+	// Bytes to download, 0 if unknown
+	DownloadSize int64 `json:"downloadSize,omitempty"`
+	// Change in installed size in bytes, negative if the new version is smaller, 0 if unknown
+	InstallSize int64 `json:"installSize,omitempty"`
 }
 
 // Equals returns true if other update is equal to self
 func (u *Update) Equals(other *Update) bool {
 	return u.NewVer == other.NewVer && u.OldVer == other.OldVer &&
-		u.Pkg == other.Pkg && u.Repo == other.Repo
+		u.Pkg == other.Pkg && u.Repo == other.Repo && u.Backend == other.Backend &&
+		u.Scope == other.Scope && u.Kind == other.Kind && u.Arch == other.Arch
 }
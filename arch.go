@@ -11,14 +11,26 @@ shellcheck 0.7.0-82 -> 0.7.0-83
 $ pikaur -Qua 2>/dev/null
  corefreq-git                          1.70-1               -> 1.71-1
  pikaur                                1.5.7-1              -> 1.5.8-1
+
+$ pacman -Sp --print-format '%n %a %s' --dbpath /tmp/checkup-db-1000 --logfile /dev/null libarchive linux
+libarchive x86_64 523632
+linux x86_64 85201632
+
+$ arch-audit --upgradable --json
+[{"name":"AVG-1328","packages":["openssl","lib32-openssl"],"status":"Fixed","severity":"Medium","type":"denial of service","affected":"1.1.1.h-1","fixed":"1.1.1.i-1","issues":["CVE-2020-1971"]}]
 */
 
 import (
 	"bufio"
+	"context"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
@@ -39,6 +51,11 @@ type updRes struct {
 	err error
 }
 
+// Group 1: name
+// Group 2: arch
+// Group 3: download size
+var rePacmanPrint = regexp.MustCompile(`(?m)^(\S+) (\S+) (\d+)$`)
+
 const pacmanTimeFmt = "2006-01-02T15:04:05-0700" // old format "2006-01-02 15:04"
 
 // Group 1: name
@@ -53,6 +70,21 @@ var rePacman = regexp.MustCompile(`(?m)^\s*(\S+)\s+(\S+)\s+->\s+(\S+)\s*$`)
 //			if upgraded, <oldVersion> -> <newVersion> (same as pacman -Qu)
 var rePacmanLog = regexp.MustCompile(`^\[(\S+)\]\s\[ALPM\]\s(\w+)\s(\S+)\s\((.*)\)$`)
 
+// archAVG is an advisory group from the Arch Linux security tracker, arch-audit uses the same format
+type archAVG struct {
+	Name     string   `json:"name"`
+	Packages []string `json:"packages"`
+	Status   string   `json:"status"`
+	Severity string   `json:"severity"`
+	Fixed    string   `json:"fixed"`
+	Issues   []string `json:"issues"`
+}
+
+var archSeverities = []string{"low", "medium", "high", "critical"}
+
+// archAudit is true if arch-audit is available, set by pacmanBackend.Detect
+var archAudit bool
+
 var supportedHelpers = []helper{
 	{
 		name: "yay",
@@ -71,25 +103,83 @@ var supportedHelpers = []helper{
 	},
 }
 
-func procPacman(ch chan<- updRes) {
+// pacmanBackend checks official repositories with checkupdates and AUR with a supported helper
+type pacmanBackend struct {
+	logFp string
+}
+
+func init() {
+	registerBackend(&pacmanBackend{logFp: "/var/log/pacman.log"})
+}
+
+func (b *pacmanBackend) Name() string { return "pacman" }
+
+func (b *pacmanBackend) Detect(ctx context.Context, distro string) bool {
+	switch distro {
+	case "arch", "manjaro":
+	default:
+		return false
+	}
+	setupAUR()
+	archAudit = checkCmd("arch-audit")
+	if archAudit {
+		log.Info("security advisories: arch-audit")
+	}
+	return true
+}
+
+func (b *pacmanBackend) Check(ctx context.Context) (api.UpdatesList, error) { return UpdateArch(ctx) }
+
+func (b *pacmanBackend) LogPaths() []string {
+	if b.logFp == "" {
+		return nil
+	}
+	return []string{b.logFp}
+}
+
+func (b *pacmanBackend) ParseLog(ctx context.Context, fp string, f *api.File) error {
+	return checkPacmanLogs(fp, f)
+}
+
+func (b *pacmanBackend) Capabilities() Capability { return CapOldVersion | CapLogs }
+
+// setupAUR picks the first available AUR helper, or the one requested with --aur
+func setupAUR() {
+	for _, h := range supportedHelpers {
+		if !checkCmd(h.name) {
+			continue
+		}
+		if args.AurHelper != "" && h.name != args.AurHelper {
+			log.Infof("%s is available but %s was requested", h.name, args.AurHelper)
+			continue
+		}
+		aur = h
+		break
+	}
+	if aur.name == "" {
+		log.Warn("no supported AUR helper found")
+	} else {
+		log.Infof("AUR helper: %s", aur.name)
+	}
+}
+
+func procPacman(ctx context.Context, ch chan<- updRes) {
 	res := updRes{}
 	defer func() {
 		ch <- res
 	}()
-	raw, err := runCmd("checkupdates")
+	raw, err := runCmd(ctx, "checkupdates")
 	if err != nil {
 		// Exit code 2 is OK, no updates
-		if exitError, ok := err.(*exec.ExitError); ok {
-			if exitError.ExitCode() != 2 {
-				res.err = err
-			}
+		if exitError, ok := err.(*exec.ExitError); !ok || exitError.ExitCode() != 2 {
+			res.err = err
 		}
 		return
 	}
 	res.upd = parsePacmanCheckUpdates(raw, rePacman, "pacman")
 }
 
-func procAUR(ch chan<- updRes) {
+func procAUR(ctx context.Context, ch chan<- updRes) {
 	res := updRes{}
 	defer func() {
 		ch <- res
@@ -98,15 +188,13 @@ func procAUR(ch chan<- updRes) {
 		log.Debug("procAUR: no AUR helper, skipping")
 		return
 	}
-	raw, err := runCmd(aur.name, aur.args)
+	raw, err := runCmd(ctx, aur.name, aur.args)
 	if err != nil {
 		if aur.name == "paru" {
 			// Exit code 1 is OK, no updates
-			if exitError, ok := err.(*exec.ExitError); ok {
-				if exitError.ExitCode() != 1 {
-					res.err = err
-					return
-				}
+			if exitError, ok := err.(*exec.ExitError); !ok || exitError.ExitCode() != 1 {
+				res.err = err
+				return
 			}
 		} else {
 			res.err = err
@@ -121,12 +209,12 @@ func procAUR(ch chan<- updRes) {
 }
 
 // UpdateArch uses checkupdates and (if available) a supported AUR helper to get available updates
-func UpdateArch() (updates api.UpdatesList, err error) {
+func UpdateArch(ctx context.Context) (updates api.UpdatesList, err error) {
 	chPac := make(chan updRes)
 	chAUR := make(chan updRes)
 	// Run in parallel
-	go procPacman(chPac)
-	go procAUR(chAUR)
+	go procPacman(ctx, chPac)
+	go procAUR(ctx, chAUR)
 	// Wait for results
 	for i := 0; i < 2; i++ {
 		select {
@@ -144,9 +232,139 @@ func UpdateArch() (updates api.UpdatesList, err error) {
 			}
 		}
 	}
+	if len(updates) > 0 {
+		if e := addPacmanSizes(ctx, updates); e != nil {
+			log.Warnf("UpdateArch: cannot get download sizes: %v", e)
+		}
+		if e := addArchSecurity(ctx, updates); e != nil {
+			log.Warnf("UpdateArch: cannot get security advisories: %v", e)
+		}
+	}
 	return
 }
 
+// checkupdatesDB returns the temporary database path used by checkupdates
+func checkupdatesDB() string {
+	if db := os.Getenv("CHECKUPDATES_DB"); db != "" {
+		return db
+	}
+	tmp := os.Getenv("TMPDIR")
+	if tmp == "" {
+		tmp = "/tmp"
+	}
+	return filepath.Join(tmp, fmt.Sprintf("checkup-db-%d", os.Getuid()))
+}
+
+// addPacmanSizes sets the architecture and download size of repository updates
+//
+// The database synced by checkupdates is used, the system one may not have the new versions yet.
+func addPacmanSizes(ctx context.Context, updates api.UpdatesList) error {
+	cmdArgs := []string{"-Sp", "--print-format", "%n %a %s", "--dbpath", checkupdatesDB(), "--logfile", "/dev/null"}
+	num := len(cmdArgs)
+	for _, u := range updates {
+		if u.Repo == "pacman" {
+			cmdArgs = append(cmdArgs, u.Pkg)
+		}
+	}
+	if len(cmdArgs) == num {
+		return nil
+	}
+	out, err := runCmd(ctx, "pacman", cmdArgs...)
+	if err != nil {
+		return err
+	}
+	setPacmanSizes(updates, out)
+	return nil
+}
+
+// setPacmanSizes sets the architecture and download size of updates from pacman --print output
+func setPacmanSizes(updates api.UpdatesList, out string) {
+	found := make(map[string][]string)
+	for _, m := range rePacmanPrint.FindAllStringSubmatch(out, -1) {
+		found[m[1]] = m[2:]
+	}
+	for i, u := range updates {
+		if u.Repo != "pacman" {
+			continue
+		}
+		if m, ok := found[u.Pkg]; ok {
+			updates[i].Arch = m[0]
+			updates[i].DownloadSize, _ = strconv.ParseInt(m[1], 10, 64)
+		}
+	}
+}
+
+// addArchSecurity marks updates which fix known vulnerabilities
+//
+// The security tracker JSON from --arch.security-file is used if set, otherwise arch-audit if available
+func addArchSecurity(ctx context.Context, updates api.UpdatesList) error {
+	var raw []byte
+	if args.ArchSecurity != "" {
+		b, err := ioutil.ReadFile(args.ArchSecurity)
+		if err != nil {
+			return err
+		}
+		raw = b
+	} else if archAudit {
+		out, err := runCmd(ctx, "arch-audit", "--upgradable", "--json")
+		if err != nil {
+			return err
+		}
+		raw = []byte(out)
+	} else {
+		log.Debug("addArchSecurity: arch-audit not found, skipping")
+		return nil
+	}
+	var avgs []archAVG
+	if err := json.Unmarshal(raw, &avgs); err != nil {
+		return fmt.Errorf("cannot unmarshal advisory groups: %v", err)
+	}
+	setArchSecurity(updates, avgs)
+	return nil
+}
+
+// setArchSecurity adds AVG IDs, CVEs and the highest severity to updates which fix an advisory group
+//
+// An update fixes an AVG if the installed version is older than the fixed one and the new one is not
+func setArchSecurity(updates api.UpdatesList, avgs []archAVG) {
+	for i, u := range updates {
+		names := make([]string, 0)
+		cves := make([]string, 0)
+		severity := -1
+		for _, a := range avgs {
+			if a.Fixed == "" || !containsString(a.Packages, u.Pkg) {
+				continue
+			}
+			if u.OldVer != "" && vercmpAlpm(u.OldVer, a.Fixed) >= 0 {
+				continue
+			}
+			if vercmpAlpm(u.NewVer, a.Fixed) < 0 {
+				continue
+			}
+			names = append(names, a.Name)
+			for _, cve := range a.Issues {
+				if !containsString(cves, cve) {
+					cves = append(cves, cve)
+				}
+			}
+			for j, s := range archSeverities {
+				if strings.EqualFold(s, a.Severity) && j > severity {
+					severity = j
+				}
+			}
+		}
+		if len(names) == 0 {
+			continue
+		}
+		updates[i].Category = "security"
+		updates[i].Advisory = strings.Join(names, ", ")
+		updates[i].CVEs = cves
+		if severity >= 0 {
+			updates[i].Severity = archSeverities[severity]
+		}
+	}
+}
+
 func parsePacmanCheckUpdates(out string, re *regexp.Regexp, repo string) api.UpdatesList {
 	updates := make(api.UpdatesList, 0)
 	for _, m := range re.FindAllStringSubmatch(out, -1) {
@@ -160,19 +378,19 @@ func parsePacmanCheckUpdates(out string, re *regexp.Regexp, repo string) api.Upd
 	return updates
 }
 
-// checkPacmanLogs read pacman log file and update internal cache accordingly
-func checkPacmanLogs(fp string) error {
+// checkPacmanLogs read pacman log file and update f accordingly
+func checkPacmanLogs(fp string, f *api.File) error {
 	file, err := os.Open(fp)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
 	scanner := bufio.NewScanner(file)
-	lastChecked, err := time.Parse(time.RFC3339, cache.f.Checked)
+	lastChecked, err := time.Parse(time.RFC3339, f.Checked)
 	if err != nil {
-		return fmt.Errorf("cannot parse cached time '%s': %v", cache.f.Checked, err)
+		return fmt.Errorf("cannot parse cached time '%s': %v", f.Checked, err)
 	}
-	beforeLen := len(cache.f.Updates)
+	beforeLen := len(f.Updates)
 	for scanner.Scan() {
 		m := rePacmanLog.FindStringSubmatch(scanner.Text())
 		if len(m) != 5 {
@@ -198,21 +416,21 @@ func checkPacmanLogs(fp string) error {
 				log.Warnf("checkPacmanLogs: expected 'old -> new', got '%s'", ver)
 				continue
 			}
-			if changed := cache.f.Remove(name, tmp[1]); changed {
+			if changed := f.Remove(name, tmp[1]); changed {
 				log.Debugf("checkPacmanLogs: removed upgraded package %s %s", name, tmp[1])
 			} else {
 				log.Debugf("checkPacmanLogs: skip upgraded package %s %s", name, tmp[1])
 			}
 		case "removed":
-			if changed := cache.f.Remove(name, ""); changed {
+			if changed := f.Remove(name, ""); changed {
 				log.Debugf("checkPacmanLogs: removed uninstalled package %s %s", name, ver)
 			} else {
 				log.Debugf("checkPacmanLogs: skip uninstalled package %s %s", name, ver)
 			}
 		}
 	}
-	if len(cache.f.Updates) != beforeLen {
-		log.Infof("checkPacmanLogs: %s: removed %d pending updates", fp, beforeLen-len(cache.f.Updates))
+	if len(f.Updates) != beforeLen {
+		log.Infof("checkPacmanLogs: %s: removed %d pending updates", fp, beforeLen-len(f.Updates))
 	}
 	return scanner.Err()
 }
@@ -1,7 +1,10 @@
 package main
 
 import (
+	"context"
 	"io/ioutil"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	log "github.com/sirupsen/logrus"
@@ -200,9 +203,109 @@ func runPacmanLogsTest(content string) error {
 	if err != nil {
 		return err
 	}
-	err = checkPacmanLogs(fp)
+	err = checkPacmanLogs(fp, &cache.f)
 	if err != nil {
 		return err
 	}
 	return nil
 }
+
+func TestArchSecurity(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+	// Subset of https://security.archlinux.org/issues/all.json
+	file := `[
+  {"name":"AVG-1328","packages":["openssl","lib32-openssl"],"status":"Fixed","severity":"Medium","type":"denial of service","affected":"1.1.1.h-1","fixed":"1.1.1.i-1","ticket":null,"issues":["CVE-2020-1971"],"advisories":["ASA-202012-5"]},
+  {"name":"AVG-1330","packages":["openssl"],"status":"Fixed","severity":"High","type":"arbitrary code execution","affected":"1.1.1.h-1","fixed":"1.1.1.i-1","ticket":null,"issues":["CVE-2020-1971","CVE-2020-1967"],"advisories":[]},
+  {"name":"AVG-1100","packages":["openssl"],"status":"Fixed","severity":"Low","type":"information disclosure","affected":"1.1.1.c-1","fixed":"1.1.1.d-1","ticket":null,"issues":["CVE-2019-1547"],"advisories":[]},
+  {"name":"AVG-1340","packages":["chromium"],"status":"Fixed","severity":"Critical","type":"arbitrary code execution","affected":"87.0.4280.88-1","fixed":"87.0.4280.141-1","ticket":null,"issues":["CVE-2021-21106"],"advisories":[]},
+  {"name":"AVG-1341","packages":["sudo"],"status":"Vulnerable","severity":"High","type":"privilege escalation","affected":"1.9.4.p1-1","fixed":null,"ticket":null,"issues":["CVE-2021-3156"],"advisories":[]}
+]`
+	fp := filepath.Join(t.TempDir(), "security.json")
+	if err := ioutil.WriteFile(fp, []byte(file), 0644); err != nil {
+		t.Fatal(err)
+	}
+	args.ArchSecurity = fp
+	defer func() { args.ArchSecurity = "" }()
+	updates := api.UpdatesList{
+		{
+			Pkg:    "openssl",
+			OldVer: "1.1.1.h-1",
+			NewVer: "1.1.1.i-1",
+			Repo:   "pacman",
+		},
+		{
+			Pkg:    "chromium",
+			OldVer: "87.0.4280.88-1",
+			NewVer: "87.0.4280.101-1",
+			Repo:   "pacman",
+		},
+		{
+			Pkg:    "sudo",
+			OldVer: "1.9.4.p1-1",
+			NewVer: "1.9.4.p2-1",
+			Repo:   "pacman",
+		},
+	}
+	if err := addArchSecurity(context.Background(), updates); err != nil {
+		t.Fatal(err)
+	}
+	expected := api.UpdatesList{
+		{
+			Category: "security",
+			Severity: "high",
+			Advisory: "AVG-1328, AVG-1330",
+			CVEs:     []string{"CVE-2020-1971", "CVE-2020-1967"},
+		},
+		// Update doesn't reach the fixed version
+		{},
+		// Not fixed yet
+		{},
+	}
+	for i, u := range updates {
+		e := expected[i]
+		if u.Advisory != e.Advisory || u.Category != e.Category || u.Severity != e.Severity {
+			t.Errorf("%s: expected %s %s %s, got %s %s %s", u.Pkg,
+				e.Advisory, e.Category, e.Severity, u.Advisory, u.Category, u.Severity)
+		}
+		if strings.Join(u.CVEs, ",") != strings.Join(e.CVEs, ",") {
+			t.Errorf("%s: expected CVEs %v, got %v", u.Pkg, e.CVEs, u.CVEs)
+		}
+	}
+}
+
+func TestArchPacmanSizes(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+	updates := api.UpdatesList{
+		{
+			Pkg:    "linux",
+			OldVer: "5.4.6.arch3-1",
+			NewVer: "5.4.7.arch1-1",
+			Repo:   "pacman",
+		},
+		{
+			Pkg:    "ca-certificates-mozilla",
+			OldVer: "3.52.1-2",
+			NewVer: "3.53-1",
+			Repo:   "pacman",
+		},
+		{
+			Pkg:    "pikaur",
+			OldVer: "1.5.7-1",
+			NewVer: "1.5.8-1",
+			Repo:   "aur",
+		},
+	}
+	out := `linux x86_64 85201632
+linux-firmware any 104127628
+ca-certificates-mozilla any 368024
+`
+	setPacmanSizes(updates, out)
+	for i, e := range []struct {
+		arch string
+		size int64
+	}{{"x86_64", 85201632}, {"any", 368024}, {"", 0}} {
+		if updates[i].Arch != e.arch || updates[i].DownloadSize != e.size {
+			t.Errorf("%s: expected %s %d, got %s %d", updates[i].Pkg, e.arch, e.size, updates[i].Arch, updates[i].DownloadSize)
+		}
+	}
+}
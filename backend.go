@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cosandr/go-check-updates/api"
+)
+
+// Capability is a bit flag describing what information a backend provides
+type Capability uint8
+
+const (
+	// CapOldVersion means the currently installed version is known
+	CapOldVersion Capability = 1 << iota
+	// CapRepo means the repository an update comes from is known
+	CapRepo
+	// CapLogs means the package manager log can be used to remove upgraded packages
+	CapLogs
+)
+
+// Has returns true if all flags in other are set
+func (c Capability) Has(other Capability) bool {
+	return c&other == other
+}
+
+func (c Capability) String() string {
+	names := make([]string, 0)
+	if c.Has(CapOldVersion) {
+		names = append(names, "oldVer")
+	}
+	if c.Has(CapRepo) {
+		names = append(names, "repo")
+	}
+	if c.Has(CapLogs) {
+		names = append(names, "logs")
+	}
+	return strings.Join(names, ",")
+}
+
+// Backend is implemented by every supported package manager
+type Backend interface {
+	// Name returns a short unique identifier, e.g. "pacman"
+	Name() string
+	// Detect returns true if this backend can be used on the host,
+	// distro is the os-release ID as returned by getDistro
+	Detect(ctx context.Context, distro string) bool
+	// Check returns a list of pending updates, it should stop as soon as possible once ctx is done
+	Check(ctx context.Context) (api.UpdatesList, error)
+	// LogPaths returns paths to the package manager logs, empty if unsupported
+	LogPaths() []string
+	// ParseLog reads the log file at fp (one of LogPaths) and removes upgraded or uninstalled packages from f,
+	// f only contains the updates found by this backend
+	ParseLog(ctx context.Context, fp string, f *api.File) error
+	// Capabilities returns what information this backend provides
+	Capabilities() Capability
+}
+
+// optionalBackend is implemented by backends which are only used if enabled explicitly
+type optionalBackend interface {
+	Optional() bool
+}
+
+// isOptional returns true if b is only used when named in the enabled backends
+func isOptional(b Backend) bool {
+	o, ok := b.(optionalBackend)
+	return ok && o.Optional()
+}
+
+// registeredBackends holds all known backends in registration order
+var registeredBackends = make([]Backend, 0)
+
+// registerBackend adds b to the list of known backends, panics if the name is taken
+func registerBackend(b Backend) {
+	if getBackend(b.Name()) != nil {
+		panic(fmt.Sprintf("backend %s registered twice", b.Name()))
+	}
+	registeredBackends = append(registeredBackends, b)
+}
+
+// getBackend returns a registered backend by name, nil if not found
+func getBackend(name string) Backend {
+	for _, b := range registeredBackends {
+		if b.Name() == name {
+			return b
+		}
+	}
+	return nil
+}
+
+// detectBackends returns all registered backends supporting distro
+//
+// If enabled is not empty, only backends with matching names are considered,
+// otherwise all backends which are not optional are.
+func detectBackends(ctx context.Context, distro string, enabled []string) ([]Backend, error) {
+	ret := make([]Backend, 0)
+	for _, name := range enabled {
+		if getBackend(name) == nil {
+			return nil, fmt.Errorf("unknown backend %s", name)
+		}
+	}
+	for _, b := range registeredBackends {
+		if len(enabled) > 0 && !containsString(enabled, b.Name()) {
+			continue
+		}
+		if len(enabled) == 0 && isOptional(b) {
+			continue
+		}
+		if b.Detect(ctx, distro) {
+			ret = append(ret, b)
+		}
+	}
+	if len(ret) == 0 {
+		return nil, fmt.Errorf("unsupported distro %s, no backends detected", distro)
+	}
+	return ret, nil
+}
+
+// backendTimeouts holds the maximum duration of a check, zero means no limit
+type backendTimeouts struct {
+	// Used for backends without their own timeout
+	def    time.Duration
+	byName map[string]time.Duration
+}
+
+// get returns the timeout of the named backend
+func (t backendTimeouts) get(name string) time.Duration {
+	if d, ok := t.byName[name]; ok {
+		return d
+	}
+	return t.def
+}
+
+// parseBackendTimeouts parses name=duration pairs, e.g. pacman=5m
+func parseBackendTimeouts(def time.Duration, pairs []string) (backendTimeouts, error) {
+	ret := backendTimeouts{def: def, byName: make(map[string]time.Duration)}
+	for _, p := range pairs {
+		cols := strings.SplitN(p, "=", 2)
+		if len(cols) != 2 {
+			return ret, fmt.Errorf("invalid backend timeout '%s', expected name=duration", p)
+		}
+		if getBackend(cols[0]) == nil {
+			return ret, fmt.Errorf("unknown backend %s", cols[0])
+		}
+		d, err := time.ParseDuration(cols[1])
+		if err != nil {
+			return ret, fmt.Errorf("invalid timeout for %s: %v", cols[0], err)
+		}
+		ret.byName[cols[0]] = d
+	}
+	return ret, nil
+}
+
+// timeoutError is returned by a refresh if some backends did not finish in time
+type timeoutError struct {
+	err      error
+	backends []string
+}
+
+func (e *timeoutError) Error() string { return e.err.Error() }
+
+// isTimeout returns true if err is caused by a timeout
+func isTimeout(err error) bool {
+	if err == context.DeadlineExceeded {
+		return true
+	}
+	_, ok := err.(*timeoutError)
+	return ok
+}
+
+// backendRes is the result of checking a single backend
+type backendRes struct {
+	name     string
+	upd      api.UpdatesList
+	err      error
+	timedOut bool
+}
+
+// checkBackends runs all backends in parallel and merges their results
+//
+// Each update is tagged with the name of the backend which found it,
+// errors are returned per backend name along with the sorted names of backends which timed out.
+// Backends are cancelled once ctx is done or their timeout is reached.
+func checkBackends(ctx context.Context, backends []Backend, timeouts backendTimeouts) (updates api.UpdatesList, errs map[string]string, timedOut []string) {
+	ch := make(chan backendRes)
+	for _, b := range backends {
+		go func(b Backend) {
+			res := backendRes{name: b.Name()}
+			bCtx, cancel := context.WithCancel(ctx)
+			timeout := timeouts.get(b.Name())
+			if timeout > 0 {
+				bCtx, cancel = context.WithTimeout(ctx, timeout)
+			}
+			defer cancel()
+			// Backends which ignore the context must not hold up the refresh, their result is discarded
+			done := make(chan backendRes, 1)
+			go func() {
+				upd, err := b.Check(bCtx)
+				done <- backendRes{upd: upd, err: err}
+			}()
+			select {
+			case r := <-done:
+				res.upd, res.err = r.upd, r.err
+			case <-bCtx.Done():
+				log.Debugf("checkBackends: %s: %v, not waiting for it", b.Name(), bCtx.Err())
+			}
+			// Results are incomplete if the context is done, backends may also hide
+			// the context error so replace it with a clear message
+			if ctx.Err() != nil {
+				res.upd, res.err = nil, ctx.Err()
+				res.timedOut = ctx.Err() == context.DeadlineExceeded
+			} else if bCtx.Err() == context.DeadlineExceeded {
+				res.upd, res.err = nil, fmt.Errorf("timed out after %v", timeout)
+				res.timedOut = true
+			}
+			ch <- res
+		}(b)
+	}
+	updates = make(api.UpdatesList, 0)
+	errs = make(map[string]string)
+	timedOut = make([]string, 0)
+	for range backends {
+		res := <-ch
+		if res.err != nil {
+			log.Debugf("checkBackends: %s failed: %v", res.name, res.err)
+			errs[res.name] = res.err.Error()
+		}
+		if res.timedOut {
+			timedOut = append(timedOut, res.name)
+		}
+		for _, u := range res.upd {
+			u.Backend = res.name
+			classifyUpdate(&u)
+			updates = append(updates, u)
+		}
+	}
+	sort.Strings(timedOut)
+	return
+}
+
+// joinErrors returns a single error from per backend error messages, nil if there are none
+func joinErrors(errs map[string]string) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(errs))
+	for name := range errs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	msgs := make([]string, len(names))
+	for i, name := range names {
+		msgs[i] = fmt.Sprintf("%s failed: %s", name, errs[name])
+	}
+	return errors.New(strings.Join(msgs, "; "))
+}
+
+// containsString returns true if s is in list
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,136 @@
+package main
+
+/*
+$ brew outdated --json=v2
+{
+  "formulae": [
+    {
+      "name": "openssl@1.1",
+      "installed_versions": ["1.1.1h"],
+      "current_version": "1.1.1i",
+      "pinned": false,
+      "pinned_version": null
+    }
+  ],
+  "casks": [
+    {
+      "name": "font-fira-code",
+      "installed_versions": "5.2",
+      "current_version": "6.2"
+    }
+  ]
+}
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cosandr/go-check-updates/api"
+)
+
+// brewOutdated is the output of brew outdated --json=v2
+type brewOutdated struct {
+	Formulae []brewPackage `json:"formulae"`
+	Casks    []brewPackage `json:"casks"`
+}
+
+// brewPackage is an outdated formula or cask
+type brewPackage struct {
+	Name string `json:"name"`
+	// List of versions for formulae, a single string for casks in older versions of brew
+	InstalledVersions json.RawMessage `json:"installed_versions"`
+	CurrentVersion    string          `json:"current_version"`
+}
+
+// installed returns the installed versions separated by commas
+func (p brewPackage) installed() string {
+	var versions []string
+	if err := json.Unmarshal(p.InstalledVersions, &versions); err == nil {
+		return strings.Join(versions, ",")
+	}
+	var ver string
+	_ = json.Unmarshal(p.InstalledVersions, &ver)
+	return ver
+}
+
+// brewBackend checks the current user's Homebrew installation
+type brewBackend struct {
+	paths []string
+	brew  string
+}
+
+func init() {
+	paths := []string{"/home/linuxbrew/.linuxbrew/bin/brew"}
+	if home, err := os.UserHomeDir(); err == nil {
+		paths = append(paths, path.Join(home, ".linuxbrew/bin/brew"))
+	}
+	registerBackend(&brewBackend{paths: paths})
+}
+
+func (b *brewBackend) Name() string { return "brew" }
+
+// Detect looks for brew in PATH and the default install locations, Homebrew refuses to run as root
+func (b *brewBackend) Detect(ctx context.Context, distro string) bool {
+	if os.Geteuid() == 0 {
+		log.Debugf("brew: running as root, skipped")
+		return false
+	}
+	if fp, err := exec.LookPath("brew"); err == nil {
+		b.brew = fp
+		return true
+	}
+	for _, fp := range b.paths {
+		if checkFileExists(fp) {
+			b.brew = fp
+			return true
+		}
+	}
+	return false
+}
+
+func (b *brewBackend) Check(ctx context.Context) (api.UpdatesList, error) {
+	raw, err := runCmd(ctx, b.brew, "outdated", "--json=v2")
+	if err != nil {
+		return api.UpdatesList{}, err
+	}
+	return parseBrewOutdated(raw)
+}
+
+func (b *brewBackend) LogPaths() []string { return nil }
+
+func (b *brewBackend) ParseLog(ctx context.Context, fp string, f *api.File) error {
+	return fmt.Errorf("brew has no log file")
+}
+
+func (b *brewBackend) Capabilities() Capability { return CapOldVersion | CapRepo }
+
+// parseBrewOutdated returns outdated formulae and casks, Repo is set to "formula" or "cask"
+func parseBrewOutdated(out string) (api.UpdatesList, error) {
+	updates := make(api.UpdatesList, 0)
+	var outdated brewOutdated
+	if err := json.Unmarshal([]byte(out), &outdated); err != nil {
+		return updates, fmt.Errorf("cannot unmarshal brew output: %v", err)
+	}
+	appendPkgs := func(pkgs []brewPackage, repo string) {
+		for _, p := range pkgs {
+			updates = append(updates, api.Update{
+				Pkg:    p.Name,
+				OldVer: p.installed(),
+				NewVer: p.CurrentVersion,
+				Repo:   repo,
+				Scope:  "user",
+			})
+		}
+	}
+	appendPkgs(outdated.Formulae, "formula")
+	appendPkgs(outdated.Casks, "cask")
+	return updates, nil
+}
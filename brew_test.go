@@ -0,0 +1,90 @@
+package main
+
+import (
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cosandr/go-check-updates/api"
+)
+
+func TestBrewParseOutdated(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+	out := `{
+  "formulae": [
+    {
+      "name": "openssl@1.1",
+      "installed_versions": ["1.1.1h"],
+      "current_version": "1.1.1i",
+      "pinned": false,
+      "pinned_version": null
+    },
+    {
+      "name": "python@3.9",
+      "installed_versions": ["3.9.0_4", "3.9.0_5"],
+      "current_version": "3.9.1_1",
+      "pinned": false,
+      "pinned_version": null
+    }
+  ],
+  "casks": [
+    {
+      "name": "font-fira-code",
+      "installed_versions": "5.2",
+      "current_version": "6.2"
+    },
+    {
+      "name": "font-jetbrains-mono",
+      "installed_versions": ["2.221"],
+      "current_version": "2.225"
+    }
+  ]
+}`
+	actual, err := parseBrewOutdated(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := api.UpdatesList{
+		{
+			Pkg:    "openssl@1.1",
+			OldVer: "1.1.1h",
+			NewVer: "1.1.1i",
+			Repo:   "formula",
+			Scope:  "user",
+		},
+		{
+			Pkg:    "python@3.9",
+			OldVer: "3.9.0_4,3.9.0_5",
+			NewVer: "3.9.1_1",
+			Repo:   "formula",
+			Scope:  "user",
+		},
+		{
+			Pkg:    "font-fira-code",
+			OldVer: "5.2",
+			NewVer: "6.2",
+			Repo:   "cask",
+			Scope:  "user",
+		},
+		{
+			Pkg:    "font-jetbrains-mono",
+			OldVer: "2.221",
+			NewVer: "2.225",
+			Repo:   "cask",
+			Scope:  "user",
+		},
+	}
+	if len(actual) != len(expected) {
+		t.Fatalf("expected %d updates, got %d: %v", len(expected), len(actual), actual)
+	}
+	for i, a := range actual {
+		if e := expected[i]; !a.Equals(&e) {
+			t.Errorf("expected %v, got %v", e, a)
+		}
+	}
+	// Nothing outdated
+	actual, err = parseBrewOutdated(`{"formulae":[],"casks":[]}`)
+	if err != nil || len(actual) != 0 {
+		t.Errorf("expected 0 updates, got %v: %v", actual, err)
+	}
+}
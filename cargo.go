@@ -0,0 +1,115 @@
+package main
+
+/*
+$ cargo install --list
+cargo-edit v0.7.0:
+    cargo-add
+    cargo-rm
+    cargo-upgrade
+ripgrep v12.1.1:
+    rg
+mytool v0.1.0 (/home/user/src/mytool):
+    mytool
+
+$ curl https://crates.io/api/v1/crates/ripgrep
+{"crate":{"id":"ripgrep","max_version":"12.1.1","max_stable_version":"12.1.1","newest_version":"12.1.1",...},...}
+*/
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cosandr/go-check-updates/api"
+)
+
+// Group 1: crate name
+// Group 2: version
+// Group 3: source if not crates.io, e.g. a path or git URL (optional)
+var reCargoInstallList = regexp.MustCompile(`(?m)^(\S+) v(\S+?)(?: \((.*)\))?:\s*$`)
+
+// cratesResponse is the subset of the crates.io crate response used here
+type cratesResponse struct {
+	Crate struct {
+		MaxVersion       string `json:"max_version"`
+		MaxStableVersion string `json:"max_stable_version"`
+	} `json:"crate"`
+}
+
+// cargoBackend checks crates installed with cargo install against the registry, must be enabled explicitly
+type cargoBackend struct {
+	registry string
+}
+
+func init() {
+	registerBackend(&cargoBackend{registry: "https://crates.io/api/v1/crates"})
+}
+
+func (b *cargoBackend) Name() string { return "cargo" }
+
+func (b *cargoBackend) Detect(ctx context.Context, distro string) bool { return checkCmd("cargo") }
+
+func (b *cargoBackend) Check(ctx context.Context) (api.UpdatesList, error) {
+	raw, err := runCmd(ctx, "cargo", "install", "--list")
+	if err != nil {
+		return api.UpdatesList{}, err
+	}
+	return b.checkRegistry(ctx, parseCargoInstallList(raw))
+}
+
+func (b *cargoBackend) LogPaths() []string { return nil }
+
+func (b *cargoBackend) ParseLog(ctx context.Context, fp string, f *api.File) error {
+	return fmt.Errorf("cargo has no log file")
+}
+
+func (b *cargoBackend) Capabilities() Capability { return CapOldVersion | CapRepo }
+
+func (b *cargoBackend) Optional() bool { return true }
+
+// checkRegistry looks up the latest stable version of every installed crate
+//
+// Crates which cannot be looked up are skipped, the first error is returned
+func (b *cargoBackend) checkRegistry(ctx context.Context, installed map[string]string) (updates api.UpdatesList, err error) {
+	updates = make(api.UpdatesList, 0)
+	for _, name := range sortedKeys(installed) {
+		var resp cratesResponse
+		if e := httpGetJSON(ctx, b.registry+"/"+name, &resp); e != nil {
+			log.Debugf("cargo: cannot look up %s: %v", name, e)
+			if err == nil {
+				err = fmt.Errorf("cannot look up %s: %v", name, e)
+			}
+			continue
+		}
+		latest := resp.Crate.MaxStableVersion
+		if latest == "" {
+			latest = resp.Crate.MaxVersion
+		}
+		if latest == "" || vercmpSemver(latest, installed[name]) <= 0 {
+			continue
+		}
+		updates = append(updates, api.Update{
+			Pkg:    name,
+			OldVer: installed[name],
+			NewVer: latest,
+			Repo:   "crates.io",
+			Scope:  "user",
+		})
+	}
+	return
+}
+
+// parseCargoInstallList returns a map of crate name -> version, crates not installed from crates.io are skipped
+func parseCargoInstallList(out string) map[string]string {
+	ret := make(map[string]string)
+	for _, m := range reCargoInstallList.FindAllStringSubmatch(out, -1) {
+		if m[3] != "" && !strings.HasPrefix(m[3], "registry+https://github.com/rust-lang/crates.io-index") {
+			continue
+		}
+		ret[m[1]] = m[2]
+	}
+	return ret
+}
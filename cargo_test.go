@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cosandr/go-check-updates/api"
+)
+
+func TestCargoCheckRegistry(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+	out := `cargo-edit v0.7.0:
+    cargo-add
+    cargo-rm
+    cargo-upgrade
+ripgrep v12.1.1:
+    rg
+mytool v0.1.0 (/home/user/src/mytool):
+    mytool
+sccache v0.2.13 (registry+https://github.com/rust-lang/crates.io-index):
+    sccache
+yanked v1.0.0:
+    yanked
+`
+	installed := parseCargoInstallList(out)
+	if len(installed) != 4 {
+		t.Fatalf("expected 4 crates.io crates, got %v", installed)
+	}
+	crates := map[string]string{
+		"cargo-edit": `{"crate":{"id":"cargo-edit","max_version":"0.8.0-beta.1","max_stable_version":"0.7.0","newest_version":"0.8.0-beta.1"}}`,
+		"ripgrep":    `{"crate":{"id":"ripgrep","max_version":"12.1.1","max_stable_version":"12.1.1","newest_version":"12.1.1"}}`,
+		"sccache":    `{"crate":{"id":"sccache","max_version":"0.2.15","max_stable_version":"0.2.15","newest_version":"0.2.15"}}`,
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("User-Agent") == "" {
+			http.Error(w, "missing user agent", http.StatusForbidden)
+			return
+		}
+		body, ok := crates[strings.TrimPrefix(r.URL.Path, "/api/v1/crates/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, body)
+	}))
+	defer ts.Close()
+	b := &cargoBackend{registry: ts.URL + "/api/v1/crates"}
+	actual, err := b.checkRegistry(context.Background(), installed)
+	if err == nil || !strings.Contains(err.Error(), "yanked") {
+		t.Errorf("expected error for yanked, got %v", err)
+	}
+	expected := api.UpdatesList{
+		{
+			Pkg:    "sccache",
+			OldVer: "0.2.13",
+			NewVer: "0.2.15",
+			Repo:   "crates.io",
+			Scope:  "user",
+		},
+	}
+	if len(actual) != len(expected) {
+		t.Fatalf("expected %d updates, got %d: %v", len(expected), len(actual), actual)
+	}
+	for i, a := range actual {
+		if e := expected[i]; !a.Equals(&e) {
+			t.Errorf("expected %v, got %v", e, a)
+		}
+	}
+}
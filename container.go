@@ -0,0 +1,299 @@
+package main
+
+/*
+$ docker ps --format '{{.ID}}\t{{.Image}}'
+3f4e5d6c7b8a	nginx:1.19
+9e8d7c6b5a4f	ghcr.io/user/app:latest
+
+$ docker inspect --format '{{.Image}}' 3f4e5d6c7b8a
+sha256:ae2feff98a0cc5095d97c6c283dcd33090770c76d63877caa99aefbbe4d8a0b8
+
+$ docker image inspect --format '{{json .RepoDigests}}' sha256:ae2feff98a0cc5095d97c6c283dcd33090770c76d63877caa99aefbbe4d8a0b8
+["nginx@sha256:4cf620a5c81390ee209398ecc18e5fb9dd0f5155cd82adcbae532fec94006fb9"]
+
+$ curl -I -H 'Accept: application/vnd.docker.distribution.manifest.list.v2+json' https://registry-1.docker.io/v2/library/nginx/manifests/1.19
+HTTP/1.1 401 Unauthorized
+Www-Authenticate: Bearer realm="https://auth.docker.io/token",service="registry.docker.io",scope="repository:library/nginx:pull"
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cosandr/go-check-updates/api"
+)
+
+const registryTimeout = 30 * time.Second
+
+// registryAccept lists manifest types, manifest lists are preferred as that is what is pulled by tag
+var registryAccept = strings.Join([]string{
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+}, ", ")
+
+// Group 1: key
+// Group 2: value
+var reWWWAuthenticate = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+var reImageID = regexp.MustCompile(`^(sha256:)?[0-9a-f]{12,64}$`)
+
+// imageRef is a normalized image reference
+type imageRef struct {
+	// Registry as shown to the user, e.g. docker.io
+	registry string
+	// Host to connect to, e.g. registry-1.docker.io
+	host string
+	// Repository path, e.g. library/nginx
+	repo string
+	tag  string
+}
+
+func (r imageRef) String() string {
+	return r.registry + "/" + r.repo + ":" + r.tag
+}
+
+// parseImageRef normalizes an image reference like docker does, references by digest are rejected
+func parseImageRef(s string) (ref imageRef, err error) {
+	if strings.Contains(s, "@") {
+		return ref, fmt.Errorf("%s is pinned to a digest", s)
+	}
+	if reImageID.MatchString(s) {
+		return ref, fmt.Errorf("%s is an image ID", s)
+	}
+	name := s
+	ref.tag = "latest"
+	if i := strings.LastIndex(s, ":"); i > strings.LastIndex(s, "/") {
+		name, ref.tag = s[:i], s[i+1:]
+	}
+	parts := strings.SplitN(name, "/", 2)
+	if len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		ref.registry, ref.repo = parts[0], parts[1]
+	} else {
+		ref.registry, ref.repo = "docker.io", name
+	}
+	ref.host = ref.registry
+	if ref.registry == "docker.io" {
+		ref.host = "registry-1.docker.io"
+		if !strings.Contains(ref.repo, "/") {
+			ref.repo = "library/" + ref.repo
+		}
+	}
+	return ref, nil
+}
+
+// shortDigest returns the first 12 characters of the digest's hash
+func shortDigest(digest string) string {
+	if i := strings.Index(digest, ":"); i >= 0 {
+		digest = digest[i+1:]
+	}
+	if len(digest) > 12 {
+		return digest[:12]
+	}
+	return digest
+}
+
+// containerBackend checks images of running containers against their registry
+type containerBackend struct {
+	cli    string
+	client *http.Client
+}
+
+func init() {
+	registerBackend(&containerBackend{cli: "podman", client: &http.Client{Timeout: registryTimeout}})
+	registerBackend(&containerBackend{cli: "docker", client: &http.Client{Timeout: registryTimeout}})
+}
+
+func (b *containerBackend) Name() string { return b.cli }
+
+// Detect returns true if the CLI can list containers, e.g. docker requires access to its socket
+func (b *containerBackend) Detect(ctx context.Context, distro string) bool {
+	_, err := runCmd(ctx, b.cli, "ps", "--quiet")
+	return err == nil
+}
+
+func (b *containerBackend) Check(ctx context.Context) (api.UpdatesList, error) {
+	raw, err := runCmd(ctx, b.cli, "ps", "--format", "{{.ID}}\t{{.Image}}")
+	if err != nil {
+		return api.UpdatesList{}, err
+	}
+	updates := make(api.UpdatesList, 0)
+	seen := make(map[string]bool)
+	remote := make(map[string]string)
+	for _, line := range strings.Split(raw, "\n") {
+		cols := strings.Split(strings.TrimSpace(line), "\t")
+		if len(cols) != 2 {
+			continue
+		}
+		ref, e := parseImageRef(cols[1])
+		if e != nil {
+			log.Debugf("%s: skip container %s: %v", b.cli, cols[0], e)
+			continue
+		}
+		local, e := b.localDigests(ctx, cols[0])
+		if e != nil || len(local) == 0 {
+			log.Debugf("%s: skip container %s, no repository digest: %v", b.cli, cols[0], e)
+			continue
+		}
+		digest, ok := remote[ref.String()]
+		if !ok {
+			digest, e = b.remoteDigest(ctx, ref)
+			if e != nil {
+				log.Debugf("%s: cannot get digest of %s: %v", b.cli, ref, e)
+				if err == nil {
+					err = fmt.Errorf("cannot get digest of %s: %v", ref, e)
+				}
+				continue
+			}
+			remote[ref.String()] = digest
+		}
+		if seen[ref.String()] || containsString(local, digest) {
+			continue
+		}
+		seen[ref.String()] = true
+		updates = append(updates, api.Update{
+			Pkg:    ref.String(),
+			OldVer: shortDigest(local[0]),
+			NewVer: shortDigest(digest),
+			Repo:   ref.registry,
+		})
+	}
+	return updates, err
+}
+
+func (b *containerBackend) LogPaths() []string { return nil }
+
+func (b *containerBackend) ParseLog(ctx context.Context, fp string, f *api.File) error {
+	return fmt.Errorf("%s has no log file", b.cli)
+}
+
+func (b *containerBackend) Capabilities() Capability { return CapOldVersion | CapRepo }
+
+// localDigests returns the repository digests of a container's image
+func (b *containerBackend) localDigests(ctx context.Context, container string) ([]string, error) {
+	raw, err := runCmd(ctx, b.cli, "inspect", "--format", "{{.Image}}", container)
+	if err != nil {
+		return nil, err
+	}
+	raw, err = runCmd(ctx, b.cli, "image", "inspect", "--format", "{{json .RepoDigests}}", strings.TrimSpace(raw))
+	if err != nil {
+		return nil, err
+	}
+	return parseRepoDigests(raw)
+}
+
+// parseRepoDigests returns the digests from a JSON list of <name>@<digest>
+func parseRepoDigests(out string) ([]string, error) {
+	var repoDigests []string
+	if err := json.Unmarshal([]byte(out), &repoDigests); err != nil {
+		return nil, fmt.Errorf("cannot unmarshal repository digests: %v", err)
+	}
+	ret := make([]string, 0, len(repoDigests))
+	for _, d := range repoDigests {
+		if i := strings.Index(d, "@"); i >= 0 {
+			ret = append(ret, d[i+1:])
+		}
+	}
+	return ret, nil
+}
+
+// remoteDigest returns the digest of ref's manifest, anonymous token authentication is used if required
+//
+// Registries on localhost are accessed over plain HTTP, like docker does by default
+func (b *containerBackend) remoteDigest(ctx context.Context, ref imageRef) (string, error) {
+	scheme := "https"
+	if strings.HasPrefix(ref.host, "localhost") || strings.HasPrefix(ref.host, "127.0.0.1") {
+		scheme = "http"
+	}
+	url := fmt.Sprintf("%s://%s/v2/%s/manifests/%s", scheme, ref.host, ref.repo, ref.tag)
+	resp, err := b.headManifest(ctx, url, "")
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		token, err := b.registryToken(ctx, resp.Header.Get("WWW-Authenticate"))
+		if err != nil {
+			return "", err
+		}
+		if resp, err = b.headManifest(ctx, url, token); err != nil {
+			return "", err
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("HEAD %s: unexpected status %s", url, resp.Status)
+	}
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		return "", fmt.Errorf("HEAD %s: no digest returned", url)
+	}
+	return digest, nil
+}
+
+func (b *containerBackend) headManifest(ctx context.Context, url string, token string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", registryAccept)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return resp, nil
+}
+
+// registryToken requests an anonymous pull token as described by a Bearer WWW-Authenticate header
+func (b *containerBackend) registryToken(ctx context.Context, header string) (string, error) {
+	if !strings.HasPrefix(header, "Bearer ") {
+		return "", fmt.Errorf("unsupported authentication '%s'", header)
+	}
+	params := make(map[string]string)
+	for _, m := range reWWWAuthenticate.FindAllStringSubmatch(header, -1) {
+		params[m[1]] = m[2]
+	}
+	if params["realm"] == "" {
+		return "", fmt.Errorf("no realm in '%s'", header)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, params["realm"], nil)
+	if err != nil {
+		return "", err
+	}
+	q := req.URL.Query()
+	for _, k := range []string{"service", "scope"} {
+		if params[k] != "" {
+			q.Set(k, params[k])
+		}
+	}
+	req.URL.RawQuery = q.Encode()
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("GET %s: unexpected status %s", params["realm"], resp.Status)
+	}
+	var t struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&t); err != nil {
+		return "", fmt.Errorf("cannot unmarshal token: %v", err)
+	}
+	if t.Token == "" {
+		t.Token = t.AccessToken
+	}
+	return t.Token, nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestContainerParseImageRef(t *testing.T) {
+	for s, expected := range map[string]imageRef{
+		"nginx":                            {registry: "docker.io", host: "registry-1.docker.io", repo: "library/nginx", tag: "latest"},
+		"nginx:1.19":                       {registry: "docker.io", host: "registry-1.docker.io", repo: "library/nginx", tag: "1.19"},
+		"grafana/grafana:7.3.6":            {registry: "docker.io", host: "registry-1.docker.io", repo: "grafana/grafana", tag: "7.3.6"},
+		"docker.io/library/nginx:latest":   {registry: "docker.io", host: "registry-1.docker.io", repo: "library/nginx", tag: "latest"},
+		"ghcr.io/user/app":                 {registry: "ghcr.io", host: "ghcr.io", repo: "user/app", tag: "latest"},
+		"localhost:5000/app:v1":            {registry: "localhost:5000", host: "localhost:5000", repo: "app", tag: "v1"},
+		"quay.io/prometheus/node-exporter": {registry: "quay.io", host: "quay.io", repo: "prometheus/node-exporter", tag: "latest"},
+	} {
+		actual, err := parseImageRef(s)
+		if err != nil {
+			t.Errorf("%s: %v", s, err)
+			continue
+		}
+		if actual != expected {
+			t.Errorf("%s: expected %+v, got %+v", s, expected, actual)
+		}
+	}
+	for _, s := range []string{
+		"nginx@sha256:4cf620a5c81390ee209398ecc18e5fb9dd0f5155cd82adcbae532fec94006fb9",
+		"ae2feff98a0c",
+	} {
+		if _, err := parseImageRef(s); err == nil {
+			t.Errorf("%s: expected error", s)
+		}
+	}
+}
+
+func TestContainerRemoteDigest(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+	const digest = "sha256:4cf620a5c81390ee209398ecc18e5fb9dd0f5155cd82adcbae532fec94006fb9"
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			if r.URL.Query().Get("scope") != "repository:library/nginx:pull" {
+				http.Error(w, "bad scope", http.StatusBadRequest)
+				return
+			}
+			fmt.Fprint(w, `{"token":"secret"}`)
+		case "/v2/library/nginx/manifests/1.19":
+			if r.Header.Get("Authorization") != "Bearer secret" {
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="registry.test",scope="repository:library/nginx:pull"`, ts.URL))
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if !strings.Contains(r.Header.Get("Accept"), "manifest.list.v2+json") {
+				http.Error(w, "bad accept", http.StatusBadRequest)
+				return
+			}
+			w.Header().Set("Docker-Content-Digest", digest)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+	b := &containerBackend{cli: "docker", client: ts.Client()}
+	ref, err := parseImageRef(strings.TrimPrefix(ts.URL, "http://") + "/library/nginx:1.19")
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err := b.remoteDigest(context.Background(), ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual != digest {
+		t.Errorf("expected %s, got %s", digest, actual)
+	}
+	ref.tag = "1.18"
+	if _, err := b.remoteDigest(context.Background(), ref); err == nil {
+		t.Errorf("expected error for missing tag")
+	}
+	local, err := parseRepoDigests(`["nginx@sha256:0b1c2d3e4f5a6b7c8d9e0b1c2d3e4f5a6b7c8d9e0b1c2d3e4f5a6b7c8d9e0b1c","docker.io/library/nginx@` + digest + `"]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(local) != 2 || !containsString(local, digest) {
+		t.Errorf("expected 2 local digests including %s, got %v", digest, local)
+	}
+	if s := shortDigest(local[0]); s != "0b1c2d3e4f5a" {
+		t.Errorf("expected short digest 0b1c2d3e4f5a, got %s", s)
+	}
+}
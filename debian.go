@@ -0,0 +1,258 @@
+package main
+
+/*
+$ apt list --upgradable 2>/dev/null
+Listing...
+base-files/focal-updates 11ubuntu5.2 amd64 [upgradable from: 11ubuntu5.1]
+libc6/focal-updates,focal-security 2.31-0ubuntu9.1 amd64 [upgradable from: 2.31-0ubuntu9]
+
+$ cat /var/log/apt/history.log
+Start-Date: 2020-12-05  10:21:33
+Commandline: apt upgrade
+Upgrade: libc6:amd64 (2.31-0ubuntu9, 2.31-0ubuntu9.1), base-files:amd64 (11ubuntu5.1, 11ubuntu5.2)
+End-Date: 2020-12-05  10:21:40
+
+$ cat /var/log/dpkg.log
+2020-12-05 10:21:35 upgrade libc6:amd64 2.31-0ubuntu9 2.31-0ubuntu9.1
+2020-12-05 10:21:36 status installed libc6:amd64 2.31-0ubuntu9.1
+2020-12-05 10:21:37 remove foo:amd64 1.0 <none>
+*/
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cosandr/go-check-updates/api"
+)
+
+const aptHistoryTimeFmt = "2006-01-02  15:04:05"
+const dpkgTimeFmt = "2006-01-02 15:04:05"
+
+// Group 1: name
+// Group 2: suite(s)
+// Group 3: new version
+// Group 4: arch
+// Group 5: old version
+var reAptList = regexp.MustCompile(`(?m)^(\S+)/(\S+)\s+(\S+)\s+(\S+)\s+\[upgradable from:\s+(\S+)\]\s*$`)
+
+// Group 1: key (Start-Date, Upgrade, Remove, etc.)
+// Group 2: value
+var reAptHistory = regexp.MustCompile(`^([\w-]+):\s+(.*)$`)
+
+// Group 1: name
+// Group 2: arch (optional)
+// Group 3: versions, "<old>, <new>" if upgraded, "<version>" if removed
+var reAptHistoryPkg = regexp.MustCompile(`([^\s:,]+)(?::([^\s,]+))?\s\(([^)]+)\)`)
+
+// Group 1: timestamp
+// Group 2: action (install, upgrade, remove, status, etc.)
+// Group 3: rest of the line
+var reDpkgLog = regexp.MustCompile(`^(\S+\s\S+)\s(\w+)\s(.*)$`)
+
+// aptBackend checks for updates using apt, the package lists must be refreshed externally
+type aptBackend struct {
+	historyFp string
+	dpkgFp    string
+}
+
+func init() {
+	registerBackend(&aptBackend{
+		historyFp: "/var/log/apt/history.log",
+		dpkgFp:    "/var/log/dpkg.log",
+	})
+}
+
+func (b *aptBackend) Name() string { return "apt" }
+
+func (b *aptBackend) Detect(ctx context.Context, distro string) bool {
+	switch distro {
+	case "debian", "ubuntu", "raspbian", "pop", "linuxmint":
+		return true
+	}
+	return false
+}
+
+func (b *aptBackend) Check(ctx context.Context) (api.UpdatesList, error) { return UpdateApt(ctx) }
+
+// LogPaths returns the apt history and dpkg logs, if present
+func (b *aptBackend) LogPaths() []string {
+	ret := make([]string, 0)
+	for _, fp := range []string{b.historyFp, b.dpkgFp} {
+		if fp != "" && checkFileExists(fp) {
+			ret = append(ret, fp)
+		}
+	}
+	return ret
+}
+
+func (b *aptBackend) ParseLog(ctx context.Context, fp string, f *api.File) error {
+	if fp == b.historyFp {
+		return checkAptHistoryLogs(fp, f)
+	}
+	return checkDpkgLogs(fp, f)
+}
+
+func (b *aptBackend) Capabilities() Capability { return CapOldVersion | CapRepo | CapLogs }
+
+// UpdateApt uses apt to list upgradable packages
+func UpdateApt(ctx context.Context) (api.UpdatesList, error) {
+	raw, err := runCmd(ctx, "apt", "list", "--upgradable")
+	if err != nil {
+		return api.UpdatesList{}, err
+	}
+	return parseAptList(raw), nil
+}
+
+func parseAptList(out string) api.UpdatesList {
+	updates := make(api.UpdatesList, 0)
+	for _, m := range reAptList.FindAllStringSubmatch(out, -1) {
+		updates = append(updates, api.Update{
+			Pkg:    m[1],
+			OldVer: m[5],
+			NewVer: m[3],
+			Repo:   m[2],
+			Arch:   m[4],
+		})
+	}
+	return updates
+}
+
+// checkAptHistoryLogs read apt history log file and update f accordingly
+func checkAptHistoryLogs(fp string, f *api.File) error {
+	file, err := os.Open(fp)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	// Upgrade lines can be very long
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	lastChecked, err := time.Parse(time.RFC3339, f.Checked)
+	if err != nil {
+		return fmt.Errorf("cannot parse cached time '%s': %v", f.Checked, err)
+	}
+	beforeLen := len(f.Updates)
+	// Entries before the first Start-Date are skipped
+	skip := true
+	for scanner.Scan() {
+		m := reAptHistory.FindStringSubmatch(scanner.Text())
+		if len(m) != 3 {
+			continue
+		}
+		key, value := m[1], m[2]
+		if key == "Start-Date" {
+			t, err := time.ParseInLocation(aptHistoryTimeFmt, value, time.Local)
+			if err != nil {
+				log.Debugf("checkAptHistoryLogs: cannot parse '%s': %v", value, err)
+				skip = true
+				continue
+			}
+			skip = t.Before(lastChecked)
+			if skip {
+				log.Debugf("checkAptHistoryLogs: skip entry, timestamp too early %v", t)
+			}
+			continue
+		}
+		if skip {
+			continue
+		}
+		switch key {
+		case "Upgrade":
+			for _, p := range reAptHistoryPkg.FindAllStringSubmatch(value, -1) {
+				name := p[1]
+				tmp := strings.Split(p[3], ", ")
+				if len(tmp) != 2 {
+					log.Warnf("checkAptHistoryLogs: expected 'old, new', got '%s'", p[3])
+					continue
+				}
+				if changed := f.RemoveArch(name, p[2], tmp[1]); changed {
+					log.Debugf("checkAptHistoryLogs: removed upgraded package %s %s", name, tmp[1])
+				} else {
+					log.Debugf("checkAptHistoryLogs: skip upgraded package %s %s", name, tmp[1])
+				}
+			}
+		case "Remove", "Purge":
+			for _, p := range reAptHistoryPkg.FindAllStringSubmatch(value, -1) {
+				name := p[1]
+				if changed := f.RemoveArch(name, p[2], ""); changed {
+					log.Debugf("checkAptHistoryLogs: removed uninstalled package %s %s", name, p[3])
+				} else {
+					log.Debugf("checkAptHistoryLogs: skip uninstalled package %s %s", name, p[3])
+				}
+			}
+		}
+	}
+	if len(f.Updates) != beforeLen {
+		log.Infof("checkAptHistoryLogs: %s: removed %d pending updates", fp, beforeLen-len(f.Updates))
+	}
+	return scanner.Err()
+}
+
+// splitDebArch splits <package>[:<arch>] into package and arch
+func splitDebArch(s string) (name string, arch string) {
+	if i := strings.Index(s, ":"); i >= 0 {
+		return s[:i], s[i+1:]
+	}
+	return s, ""
+}
+
+// checkDpkgLogs read dpkg log file and update f accordingly
+func checkDpkgLogs(fp string, f *api.File) error {
+	file, err := os.Open(fp)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	lastChecked, err := time.Parse(time.RFC3339, f.Checked)
+	if err != nil {
+		return fmt.Errorf("cannot parse cached time '%s': %v", f.Checked, err)
+	}
+	beforeLen := len(f.Updates)
+	for scanner.Scan() {
+		m := reDpkgLog.FindStringSubmatch(scanner.Text())
+		if len(m) != 4 {
+			continue
+		}
+		timestamp, action, fields := m[1], m[2], strings.Fields(m[3])
+		t, err := time.ParseInLocation(dpkgTimeFmt, timestamp, time.Local)
+		if err != nil {
+			log.Debugf("checkDpkgLogs: cannot parse '%s': %v", timestamp, err)
+			continue
+		}
+		if t.Before(lastChecked) {
+			continue
+		}
+		switch action {
+		case "status":
+			// status installed <package>:<arch> <version>
+			if len(fields) != 3 || fields[0] != "installed" {
+				continue
+			}
+			name, arch := splitDebArch(fields[1])
+			if changed := f.RemoveArch(name, arch, fields[2]); changed {
+				log.Debugf("checkDpkgLogs: removed upgraded package %s %s", name, fields[2])
+			}
+		case "remove", "purge":
+			// remove <package>:<arch> <version> <none>
+			if len(fields) < 2 {
+				continue
+			}
+			name, arch := splitDebArch(fields[0])
+			if changed := f.RemoveArch(name, arch, ""); changed {
+				log.Debugf("checkDpkgLogs: removed uninstalled package %s %s", name, fields[1])
+			}
+		}
+	}
+	if len(f.Updates) != beforeLen {
+		log.Infof("checkDpkgLogs: %s: removed %d pending updates", fp, beforeLen-len(f.Updates))
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cosandr/go-check-updates/api"
+)
+
+func TestDebianParseAptList(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+	out := `Listing...
+base-files/focal-updates 11ubuntu5.2 amd64 [upgradable from: 11ubuntu5.1]
+libc-bin/focal-updates,focal-security 2.31-0ubuntu9.1 amd64 [upgradable from: 2.31-0ubuntu9]
+libc6/focal-updates,focal-security 2.31-0ubuntu9.1 amd64 [upgradable from: 2.31-0ubuntu9]
+libnss-systemd/focal-updates 245.4-4ubuntu3.3 amd64 [upgradable from: 245.4-4ubuntu3.2]
+python3-software-properties/focal-updates 0.98.9.3 all [upgradable from: 0.98.9.2]
+tzdata/focal-updates 2020d-0ubuntu0.20.04 all [upgradable from: 2020a-0ubuntu0.20.04]
+vim-tiny/focal-updates 2:8.1.2269-1ubuntu5 amd64 [upgradable from: 2:8.1.2269-1ubuntu4]
+`
+	actual := parseAptList(out)
+	expected := api.UpdatesList{
+		{
+			Pkg:    "base-files",
+			OldVer: "11ubuntu5.1",
+			NewVer: "11ubuntu5.2",
+			Repo:   "focal-updates",
+			Arch:   "amd64",
+		},
+		{
+			Pkg:    "libc-bin",
+			OldVer: "2.31-0ubuntu9",
+			NewVer: "2.31-0ubuntu9.1",
+			Repo:   "focal-updates,focal-security",
+			Arch:   "amd64",
+		},
+		{
+			Pkg:    "libc6",
+			OldVer: "2.31-0ubuntu9",
+			NewVer: "2.31-0ubuntu9.1",
+			Repo:   "focal-updates,focal-security",
+			Arch:   "amd64",
+		},
+		{
+			Pkg:    "libnss-systemd",
+			OldVer: "245.4-4ubuntu3.2",
+			NewVer: "245.4-4ubuntu3.3",
+			Repo:   "focal-updates",
+			Arch:   "amd64",
+		},
+		{
+			Pkg:    "python3-software-properties",
+			OldVer: "0.98.9.2",
+			NewVer: "0.98.9.3",
+			Repo:   "focal-updates",
+			Arch:   "all",
+		},
+		{
+			Pkg:    "tzdata",
+			OldVer: "2020a-0ubuntu0.20.04",
+			NewVer: "2020d-0ubuntu0.20.04",
+			Repo:   "focal-updates",
+			Arch:   "all",
+		},
+		{
+			Pkg:    "vim-tiny",
+			OldVer: "2:8.1.2269-1ubuntu4",
+			NewVer: "2:8.1.2269-1ubuntu5",
+			Repo:   "focal-updates",
+			Arch:   "amd64",
+		},
+	}
+	if len(actual) != len(expected) {
+		t.Fatalf("expected %d updates, got %d", len(expected), len(actual))
+	}
+	for i, a := range actual {
+		if e := expected[i]; !a.Equals(&e) {
+			t.Errorf("expected %v, got %v", e, a)
+		}
+	}
+	// Nothing to upgrade
+	if actual = parseAptList("Listing...\n"); len(actual) != 0 {
+		t.Errorf("expected 0 updates, got %v", actual)
+	}
+}
+
+func TestDebianAptHistoryLogs(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+	cache = NewInternalCache()
+	cache.f.Checked = time.Date(2020, 12, 5, 10, 0, 0, 0, time.Local).Format(time.RFC3339)
+	allUpdates := api.UpdatesList{
+		{
+			Pkg:    "base-files",
+			NewVer: "11ubuntu5.2",
+		},
+		{
+			Pkg:    "libc6",
+			NewVer: "2.31-0ubuntu9.1",
+		},
+		{
+			Pkg:    "vim-tiny",
+			NewVer: "2:8.1.2269-1ubuntu5",
+		},
+	}
+	file := `
+Start-Date: 2020-12-04  18:02:11
+Commandline: apt upgrade
+Upgrade: base-files:amd64 (11ubuntu5, 11ubuntu5.2)
+End-Date: 2020-12-04  18:02:15
+
+Start-Date: 2020-12-05  10:21:33
+Commandline: apt upgrade
+Requested-By: andrei (1000)
+Upgrade: libc6:amd64 (2.31-0ubuntu9, 2.31-0ubuntu9.1), vim-tiny:amd64 (2:8.1.2269-1ubuntu4, 2:8.1.2269-1ubuntu5)
+End-Date: 2020-12-05  10:21:40
+`
+	// First entry is too old
+	cache.f.Updates = allUpdates.Copy()
+	if err := runAptHistoryLogsTest(file); err != nil {
+		t.Fatal(err)
+	}
+	if len(cache.f.Updates) != 1 || cache.f.Updates[0].Pkg != "base-files" {
+		t.Errorf("Expected only base-files, got %d: %v", len(cache.f.Updates), cache.f.Updates)
+	}
+	// Remove a package, upgrade one with wrong version
+	file = `
+Start-Date: 2020-12-05  10:21:33
+Commandline: apt autoremove --purge vim-tiny
+Purge: vim-tiny:amd64 (2:8.1.2269-1ubuntu4)
+End-Date: 2020-12-05  10:21:40
+
+Start-Date: 2020-12-05  10:25:01
+Commandline: /usr/bin/unattended-upgrade
+Upgrade: libc6:amd64 (2.31-0ubuntu9, 2.31-0ubuntu9.2), base-files:amd64 (11ubuntu5.1, 11ubuntu5.2)
+End-Date: 2020-12-05  10:25:08
+`
+	cache.f.Updates = allUpdates.Copy()
+	if err := runAptHistoryLogsTest(file); err != nil {
+		t.Fatal(err)
+	}
+	if len(cache.f.Updates) != 1 || cache.f.Updates[0].Pkg != "libc6" {
+		t.Errorf("Expected only libc6, got %d: %v", len(cache.f.Updates), cache.f.Updates)
+	}
+}
+
+func TestDebianDpkgLogs(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+	cache = NewInternalCache()
+	cache.f.Checked = time.Date(2020, 12, 5, 10, 0, 0, 0, time.Local).Format(time.RFC3339)
+	allUpdates := api.UpdatesList{
+		{
+			Pkg:    "base-files",
+			NewVer: "11ubuntu5.2",
+		},
+		{
+			Pkg:    "libc6",
+			NewVer: "2.31-0ubuntu9.1",
+		},
+		{
+			Pkg:    "tzdata",
+			NewVer: "2020d-0ubuntu0.20.04",
+		},
+	}
+	file := `
+2020-12-04 18:02:13 status installed base-files:amd64 11ubuntu5.2
+2020-12-05 10:21:34 startup archives unpack
+2020-12-05 10:21:35 upgrade libc6:amd64 2.31-0ubuntu9 2.31-0ubuntu9.1
+2020-12-05 10:21:35 status half-configured libc6:amd64 2.31-0ubuntu9.1
+2020-12-05 10:21:36 status installed libc6:amd64 2.31-0ubuntu9.1
+2020-12-05 10:21:37 remove tzdata:all 2020a-0ubuntu0.20.04 <none>
+2020-12-05 10:21:37 status not-installed tzdata:all <none>
+`
+	cache.f.Updates = allUpdates.Copy()
+	if err := runDpkgLogsTest(file); err != nil {
+		t.Fatal(err)
+	}
+	if len(cache.f.Updates) != 1 || cache.f.Updates[0].Pkg != "base-files" {
+		t.Errorf("Expected only base-files, got %d: %v", len(cache.f.Updates), cache.f.Updates)
+	}
+	// Only the upgraded architecture is removed
+	cache.f.Updates = api.UpdatesList{
+		{
+			Pkg:    "libc6",
+			NewVer: "2.31-0ubuntu9.1",
+			Arch:   "amd64",
+		},
+		{
+			Pkg:    "libc6",
+			NewVer: "2.31-0ubuntu9.1",
+			Arch:   "i386",
+		},
+	}
+	if err := runDpkgLogsTest(file); err != nil {
+		t.Fatal(err)
+	}
+	if len(cache.f.Updates) != 1 || cache.f.Updates[0].Arch != "i386" {
+		t.Errorf("Expected only libc6:i386, got %d: %v", len(cache.f.Updates), cache.f.Updates)
+	}
+}
+
+func runAptHistoryLogsTest(content string) error {
+	fp := "/tmp/apt_history_test.log"
+	err := ioutil.WriteFile(fp, []byte(content), 0644)
+	if err != nil {
+		return err
+	}
+	return checkAptHistoryLogs(fp, &cache.f)
+}
+
+func runDpkgLogsTest(content string) error {
+	fp := "/tmp/dpkg_test.log"
+	err := ioutil.WriteFile(fp, []byte(content), 0644)
+	if err != nil {
+		return err
+	}
+	return checkDpkgLogs(fp, &cache.f)
+}
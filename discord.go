@@ -16,7 +16,12 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
-var prevNotifyUpdates api.UpdatesList
+// notifyState holds what the previous notification was sent for, it is owned by the notify goroutine
+type notifyState struct {
+	updates api.UpdatesList
+	reboot  bool
+	sent    time.Time
+}
 
 // https://discord.com/developers/docs/resources/channel#embed-limits
 const (
@@ -31,6 +36,9 @@ const (
 	webhookMaxEmbeds    = 10
 )
 
+// embedColorSecurity is used if there are pending security updates
+const embedColorSecurity = 0xE74C3C
+
 // diffUpdates returns a list of updates present in new but not in old
 func diffUpdates(old *api.UpdatesList, new *api.UpdatesList) api.UpdatesList {
 	diff := make(api.UpdatesList, 0)
@@ -43,6 +51,65 @@ func diffUpdates(old *api.UpdatesList, new *api.UpdatesList) api.UpdatesList {
 	return diff
 }
 
+// securityFirst returns a copy of updates with security updates first, and the number of security updates
+func securityFirst(updates api.UpdatesList) (api.UpdatesList, int) {
+	ret := make(api.UpdatesList, 0, len(updates))
+	for _, u := range updates {
+		if u.Category == "security" {
+			ret = append(ret, u)
+		}
+	}
+	num := len(ret)
+	for _, u := range updates {
+		if u.Category != "security" {
+			ret = append(ret, u)
+		}
+	}
+	return ret, num
+}
+
+// updateAdvisory returns a short description of the advisory fixed by u, empty if there is none
+//
+// e.g. FEDORA-2020-9f8e7d6c5b (security/important): CVE-2020-1971, CVE-2020-1967
+func updateAdvisory(u *api.Update) string {
+	ret := u.Advisory
+	if u.Category != "" {
+		cat := u.Category
+		if u.Severity != "" {
+			cat += "/" + u.Severity
+		}
+		ret += fmt.Sprintf(" (%s)", cat)
+	}
+	if len(u.CVEs) > 0 {
+		ret += ": " + strings.Join(u.CVEs, ", ")
+	}
+	return strings.TrimSpace(ret)
+}
+
+// formatBytes returns n in a human readable binary unit, e.g. 1.2 GiB
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for i := n / unit; i >= unit; i /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// restartSummary returns a comma separated list of at most max services, followed by the number omitted
+//
+// e.g. NetworkManager.service, sshd.service and 3 more
+func restartSummary(services []string, max int) string {
+	if len(services) <= max {
+		return strings.Join(services, ", ")
+	}
+	return fmt.Sprintf("%s and %d more", strings.Join(services[:max], ", "), len(services)-max)
+}
+
 // embedExceedsLimits returns true if embed total character count is too large
 func embedExceedsLimits(embed *discordgo.MessageEmbed) bool {
 	lenTitle := len(embed.Title)
@@ -93,19 +160,25 @@ func embedExceedsLimits(embed *discordgo.MessageEmbed) bool {
 	return total > embedMaxTotal
 }
 
-func sendUpdatesNotification() error {
+// sendUpdatesNotification sends the updates in f, compared to the previous notification
+func sendUpdatesNotification(f *api.File, prev *notifyState) error {
 	hostname, err := os.Hostname()
 	if err != nil {
 		return err
 	}
+	// Patches only group package updates, don't count them twice
+	packages := f.Updates.Packages()
 	embed := discordgo.MessageEmbed{
-		Title: fmt.Sprintf("%s: %d pending updates", hostname, len(cache.f.Updates)),
+		Title: fmt.Sprintf("%s: %d pending updates", hostname, len(packages)),
+	}
+	if patches := len(f.Updates) - len(packages); patches > 0 {
+		embed.Title += fmt.Sprintf(", %d patches", patches)
 	}
-	added := diffUpdates(&prevNotifyUpdates, &cache.f.Updates)
+	added := diffUpdates(&prev.updates, &f.Updates)
 	if len(added) > 0 {
 		embed.Title += fmt.Sprintf(", %d added", len(added))
 	}
-	removed := diffUpdates(&cache.f.Updates, &prevNotifyUpdates)
+	removed := diffUpdates(&f.Updates, &prev.updates)
 	if len(removed) > 0 {
 		embed.Title += fmt.Sprintf(", %d removed", len(removed))
 	}
@@ -113,12 +186,40 @@ func sendUpdatesNotification() error {
 	if args.NotifyDelta {
 		checkList = added
 	} else {
-		checkList = cache.f.Updates
+		checkList = f.Updates
+	}
+	if len(args.NotifyChange) > 0 {
+		checkList = checkList.FilterChange(args.NotifyChange)
+		if len(checkList) == 0 && f.RebootRequired == prev.reboot {
+			log.Debugf("no updates with change levels %v, not sending", args.NotifyChange)
+			return nil
+		}
+	}
+	if downgrades := checkList.Downgrades(); len(downgrades) > 0 {
+		embed.Title += fmt.Sprintf(", %d downgrades", len(downgrades))
+	}
+	checkList, numSecurity := securityFirst(checkList)
+	if numSecurity > 0 {
+		embed.Title += fmt.Sprintf(", %d security", numSecurity)
+		embed.Color = embedColorSecurity
+	}
+	if f.RebootRequired {
+		embed.Title += ", reboot required"
 	}
 	num := len(checkList)
-	if t, err := time.Parse(time.RFC3339, cache.f.Checked); err == nil {
+	var footer string
+	if len(f.ServicesToRestart) > 0 {
+		footer = fmt.Sprintf("Restart %s\n", restartSummary(f.ServicesToRestart, 10))
+	}
+	if size := checkList.DownloadSize(); size > 0 {
+		footer += fmt.Sprintf("%s to download\n", formatBytes(size))
+	}
+	if t, err := time.Parse(time.RFC3339, f.Checked); err == nil {
+		footer += fmt.Sprintf("Checked %s", t.Format(args.NotifyFormat))
+	}
+	if footer != "" {
 		embed.Footer = &discordgo.MessageEmbedFooter{
-			Text: fmt.Sprintf("Checked %s", t.Format(args.NotifyFormat)),
+			Text: strings.TrimSpace(footer),
 		}
 	}
 	if num <= embedMaxFields {
@@ -131,6 +232,12 @@ func sendUpdatesNotification() error {
 			} else {
 				field.Value += u.NewVer
 			}
+			if u.Downgrade {
+				field.Value += " (downgrade)"
+			}
+			if adv := updateAdvisory(&u); adv != "" {
+				field.Value += "\n" + adv
+			}
 			embed.Fields[i] = &field
 		}
 	}
@@ -147,6 +254,12 @@ func sendUpdatesNotification() error {
 			} else {
 				embed.Description += fmt.Sprintf(" [%s]", u.NewVer)
 			}
+			if u.Downgrade {
+				embed.Description += " (downgrade)"
+			}
+			if adv := updateAdvisory(&u); adv != "" {
+				embed.Description += " " + adv
+			}
 		}
 		embed.Description = strings.TrimSpace(embed.Description)
 	}
@@ -73,6 +73,81 @@ func TestDiscordDiffUpdates(t *testing.T) {
 	}
 }
 
+func TestDiscordUpdateAdvisory(t *testing.T) {
+	for expected, u := range map[string]api.Update{
+		"": {Pkg: "pkg1", NewVer: "v1"},
+		"FEDORA-2020-9f8e7d6c5b (security/important): CVE-2020-1971, CVE-2020-1967": {
+			Pkg:      "openssl-libs",
+			NewVer:   "1:1.1.1i-1.fc33",
+			Advisory: "FEDORA-2020-9f8e7d6c5b",
+			Category: "security",
+			Severity: "important",
+			CVEs:     []string{"CVE-2020-1971", "CVE-2020-1967"},
+		},
+		"FEDORA-2020-1a2b3c4d5e (enhancement)": {
+			Pkg:      "python3-pip",
+			NewVer:   "20.2.2-1.fc33",
+			Advisory: "FEDORA-2020-1a2b3c4d5e",
+			Category: "enhancement",
+		},
+		"(recommended)": {Pkg: "openSUSE-2020-2345", NewVer: "1", Category: "recommended"},
+	} {
+		if actual := updateAdvisory(&u); actual != expected {
+			t.Errorf("expected '%s', got '%s'", expected, actual)
+		}
+	}
+}
+
+func TestDiscordSecurityFirst(t *testing.T) {
+	updates := api.UpdatesList{
+		{Pkg: "pkg1", NewVer: "v1"},
+		{Pkg: "pkg2", NewVer: "v2", Category: "security"},
+		{Pkg: "pkg3", NewVer: "v3", Category: "bugfix"},
+		{Pkg: "pkg4", NewVer: "v4", Category: "security"},
+	}
+	actual, num := securityFirst(updates)
+	if num != 2 {
+		t.Errorf("expected 2 security updates, got %d", num)
+	}
+	expected := []string{"pkg2", "pkg4", "pkg1", "pkg3"}
+	for i, u := range actual {
+		if u.Pkg != expected[i] {
+			t.Errorf("expected %s at %d, got %s", expected[i], i, u.Pkg)
+		}
+	}
+	if updates[0].Pkg != "pkg1" {
+		t.Errorf("input list was modified")
+	}
+}
+
+func TestDiscordRestartSummary(t *testing.T) {
+	services := []string{"NetworkManager.service", "sshd.service", "tmux: server"}
+	for max, expected := range map[int]string{
+		3: "NetworkManager.service, sshd.service, tmux: server",
+		5: "NetworkManager.service, sshd.service, tmux: server",
+		1: "NetworkManager.service and 2 more",
+	} {
+		if actual := restartSummary(services, max); actual != expected {
+			t.Errorf("expected '%s', got '%s'", expected, actual)
+		}
+	}
+}
+
+func TestDiscordFormatBytes(t *testing.T) {
+	for n, expected := range map[int64]string{
+		0:          "0 B",
+		1023:       "1023 B",
+		1024:       "1.0 KiB",
+		461556:     "450.7 KiB",
+		90456064:   "86.3 MiB",
+		1288490188: "1.2 GiB",
+	} {
+		if actual := formatBytes(n); actual != expected {
+			t.Errorf("%d: expected '%s', got '%s'", n, expected, actual)
+		}
+	}
+}
+
 func TestDiscordSendUpdatesNotificationDiff(t *testing.T) {
 	log.SetLevel(log.DebugLevel)
 	args.WebhookURL = os.Getenv("WEBHOOK_URL")
@@ -96,9 +171,8 @@ func TestDiscordSendUpdatesNotificationDiff(t *testing.T) {
 		},
 	}
 	// Test with same updates
-	prevNotifyUpdates = make(api.UpdatesList, len(cache.f.Updates))
-	copy(prevNotifyUpdates, cache.f.Updates)
-	if err := sendUpdatesNotification(); err != nil {
+	prev := notifyState{updates: cache.f.Updates.Copy()}
+	if err := sendUpdatesNotification(&cache.f, &prev); err != nil {
 		t.Error(err)
 		return
 	}
@@ -108,7 +182,7 @@ func TestDiscordSendUpdatesNotificationDiff(t *testing.T) {
 		NewVer: "v3",
 		Repo:   "updates",
 	})
-	if err := sendUpdatesNotification(); err != nil {
+	if err := sendUpdatesNotification(&cache.f, &prev); err != nil {
 		t.Error(err)
 		return
 	}
@@ -120,7 +194,7 @@ func TestDiscordSendUpdatesNotificationDiff(t *testing.T) {
 			Repo:   "updates",
 		},
 	}
-	if err := sendUpdatesNotification(); err != nil {
+	if err := sendUpdatesNotification(&cache.f, &prev); err != nil {
 		t.Error(err)
 		return
 	}
@@ -139,7 +213,7 @@ func TestDiscordSendUpdatesNotification(t *testing.T) {
 	for _, num := range []int{15, 30, 150, 9001} {
 		cache.f.Updates = generateUpdates(num)
 		cache.f.Checked = time.Now().Format(time.RFC3339)
-		if err := sendUpdatesNotification(); err != nil {
+		if err := sendUpdatesNotification(&cache.f, &notifyState{}); err != nil {
 			t.Error(err)
 			return
 		}
@@ -0,0 +1,153 @@
+package main
+
+/*
+$ flatpak list --system --columns=application,arch,branch,version,active,origin
+org.gimp.GIMP	x86_64	stable	2.10.20	f6f2ba2e2a8b	flathub
+org.freedesktop.Platform	x86_64	20.08	20.08.2	7a0f5a4b1c2d	flathub
+
+$ flatpak remote-ls --system --updates --columns=application,arch,branch,version,commit,origin
+org.gimp.GIMP	x86_64	stable	2.10.22	0b1c2d3e4f5a	flathub
+org.freedesktop.Platform	x86_64	20.08	20.08.3	1d2e3f4a5b6c	flathub
+*/
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cosandr/go-check-updates/api"
+)
+
+const flatpakListColumns = "--columns=application,arch,branch,version,active,origin"
+const flatpakUpdatesColumns = "--columns=application,arch,branch,version,commit,origin"
+
+// flatpakRef is a single installed or available flatpak
+type flatpakRef struct {
+	app    string
+	arch   string
+	branch string
+	ver    string
+	commit string
+	origin string
+}
+
+func (r flatpakRef) key() string {
+	return r.app + "/" + r.arch + "/" + r.branch
+}
+
+// flatpakBackend checks system and current user flatpak installations
+type flatpakBackend struct{}
+
+func init() {
+	registerBackend(&flatpakBackend{})
+}
+
+func (b *flatpakBackend) Name() string { return "flatpak" }
+
+func (b *flatpakBackend) Detect(ctx context.Context, distro string) bool { return checkCmd("flatpak") }
+
+func (b *flatpakBackend) Check(ctx context.Context) (api.UpdatesList, error) {
+	return UpdateFlatpak(ctx)
+}
+
+func (b *flatpakBackend) LogPaths() []string { return nil }
+
+func (b *flatpakBackend) ParseLog(ctx context.Context, fp string, f *api.File) error {
+	return fmt.Errorf("flatpak has no log file")
+}
+
+func (b *flatpakBackend) Capabilities() Capability { return CapOldVersion | CapRepo }
+
+// UpdateFlatpak checks both system and user installations for updates
+func UpdateFlatpak(ctx context.Context) (updates api.UpdatesList, err error) {
+	updates = make(api.UpdatesList, 0)
+	for _, scope := range []string{"system", "user"} {
+		upd, e := checkFlatpakInstallation(ctx, scope)
+		if e != nil {
+			// User installation might not exist, only system is fatal
+			if scope == "system" {
+				err = fmt.Errorf("%s installation: %v", scope, e)
+			} else {
+				log.Debugf("UpdateFlatpak: %s installation: %v", scope, e)
+			}
+			continue
+		}
+		updates = append(updates, upd...)
+	}
+	return
+}
+
+func checkFlatpakInstallation(ctx context.Context, scope string) (api.UpdatesList, error) {
+	raw, err := runCmd(ctx, "flatpak", "list", "--"+scope, flatpakListColumns)
+	if err != nil {
+		return nil, err
+	}
+	installed := parseFlatpakColumns(raw)
+	raw, err = runCmd(ctx, "flatpak", "remote-ls", "--"+scope, "--updates", flatpakUpdatesColumns)
+	if err != nil {
+		return nil, err
+	}
+	return parseFlatpakUpdates(raw, installed, scope), nil
+}
+
+// shortCommit returns the commit shortened to 12 characters, like flatpak does
+func shortCommit(commit string) string {
+	if len(commit) > 12 {
+		return commit[:12]
+	}
+	return commit
+}
+
+// parseFlatpakColumns parses tab separated flatpak output
+func parseFlatpakColumns(out string) []flatpakRef {
+	ret := make([]flatpakRef, 0)
+	for _, line := range strings.Split(out, "\n") {
+		cols := strings.Split(line, "\t")
+		if len(cols) != 6 || cols[0] == "" {
+			continue
+		}
+		ret = append(ret, flatpakRef{
+			app:    cols[0],
+			arch:   cols[1],
+			branch: cols[2],
+			ver:    cols[3],
+			commit: shortCommit(cols[4]),
+			origin: cols[5],
+		})
+	}
+	return ret
+}
+
+// parseFlatpakUpdates returns updates found in remote-ls output
+//
+// Versions are used if both are known and differ, otherwise commits are shown
+func parseFlatpakUpdates(out string, installed []flatpakRef, scope string) api.UpdatesList {
+	updates := make(api.UpdatesList, 0)
+	current := make(map[string]flatpakRef, len(installed))
+	for _, r := range installed {
+		current[r.key()] = r
+	}
+	for _, a := range parseFlatpakColumns(out) {
+		u := api.Update{
+			Pkg:    a.app + "//" + a.branch,
+			NewVer: a.commit,
+			Repo:   a.origin,
+			Scope:  scope,
+		}
+		i, ok := current[a.key()]
+		if !ok {
+			if a.ver != "" {
+				u.NewVer = a.ver
+			}
+		} else if a.ver != "" && i.ver != "" && a.ver != i.ver {
+			u.OldVer = i.ver
+			u.NewVer = a.ver
+		} else {
+			u.OldVer = i.commit
+		}
+		updates = append(updates, u)
+	}
+	return updates
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cosandr/go-check-updates/api"
+)
+
+func TestFlatpakParseUpdates(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+	list := "org.gimp.GIMP\tx86_64\tstable\t2.10.20\tf6f2ba2e2a8b\tflathub\n" +
+		"org.freedesktop.Platform\tx86_64\t19.08\t19.08.14\t2c3d4e5f6a7b\tflathub\n" +
+		"org.freedesktop.Platform\tx86_64\t20.08\t20.08.2\t7a0f5a4b1c2d\tflathub\n" +
+		"org.freedesktop.Platform.GL.default\tx86_64\t20.08\t\t9e8d7c6b5a4f\tflathub\n" +
+		"com.valvesoftware.Steam\tx86_64\tstable\t1.0.0.66\t3f4e5d6c7b8a\tflathub\n"
+	updates := "org.gimp.GIMP\tx86_64\tstable\t2.10.22\t0b1c2d3e4f5a6b7c8d9e\tflathub\n" +
+		"org.freedesktop.Platform\tx86_64\t20.08\t20.08.3\t1d2e3f4a5b6c\tflathub\n" +
+		"org.freedesktop.Platform.GL.default\tx86_64\t20.08\t\tb1a2c3d4e5f6a7b8c9d0e1f2\tflathub\n" +
+		"com.valvesoftware.Steam\tx86_64\tstable\t1.0.0.66\t8a7b6c5d4e3f\tflathub\n"
+	actual := parseFlatpakUpdates(updates, parseFlatpakColumns(list), "system")
+	expected := api.UpdatesList{
+		{
+			Pkg:    "org.gimp.GIMP//stable",
+			OldVer: "2.10.20",
+			NewVer: "2.10.22",
+			Repo:   "flathub",
+			Scope:  "system",
+		},
+		{
+			Pkg:    "org.freedesktop.Platform//20.08",
+			OldVer: "20.08.2",
+			NewVer: "20.08.3",
+			Repo:   "flathub",
+			Scope:  "system",
+		},
+		{
+			Pkg:    "org.freedesktop.Platform.GL.default//20.08",
+			OldVer: "9e8d7c6b5a4f",
+			NewVer: "b1a2c3d4e5f6",
+			Repo:   "flathub",
+			Scope:  "system",
+		},
+		{
+			Pkg:    "com.valvesoftware.Steam//stable",
+			OldVer: "3f4e5d6c7b8a",
+			NewVer: "8a7b6c5d4e3f",
+			Repo:   "flathub",
+			Scope:  "system",
+		},
+	}
+	if len(actual) != len(expected) {
+		t.Fatalf("expected %d updates, got %d: %v", len(expected), len(actual), actual)
+	}
+	for i, a := range actual {
+		if e := expected[i]; !a.Equals(&e) {
+			t.Errorf("expected %v, got %v", e, a)
+		}
+	}
+	// Nothing installed in user installation
+	actual = parseFlatpakUpdates("", parseFlatpakColumns(""), "user")
+	if len(actual) != 0 {
+		t.Errorf("expected 0 updates, got %v", actual)
+	}
+}
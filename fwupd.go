@@ -0,0 +1,114 @@
+package main
+
+/*
+$ fwupdmgr get-updates --json
+{
+  "Devices" : [
+    {
+      "Name" : "XPS 13 9310",
+      "DeviceId" : "6d8a1ad4a0e5e8a1de1c2b4b3e5b3b4e0a7c2f1d",
+      "Version" : "2.2.0",
+      "Vendor" : "Dell Inc.",
+      "Releases" : [
+        {
+          "AppstreamId" : "com.dell.uefi3a5bc8f1.firmware",
+          "RemoteId" : "lvfs",
+          "Name" : "XPS 13 9310 System Update",
+          "Version" : "2.4.1",
+          "Urgency" : "high"
+        }
+      ]
+    }
+  ]
+}
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+
+	"github.com/cosandr/go-check-updates/api"
+)
+
+// fwupdReply is the output of fwupdmgr get-updates --json
+type fwupdReply struct {
+	Devices []fwupdDevice `json:"Devices"`
+}
+
+// fwupdDevice is the subset of device information used here
+type fwupdDevice struct {
+	Name     string         `json:"Name"`
+	DeviceID string         `json:"DeviceId"`
+	Version  string         `json:"Version"`
+	Releases []fwupdRelease `json:"Releases"`
+}
+
+// fwupdRelease is a firmware release available for a device
+type fwupdRelease struct {
+	RemoteID string `json:"RemoteId"`
+	Version  string `json:"Version"`
+	Urgency  string `json:"Urgency"`
+}
+
+// fwupdBackend checks for device firmware updates, metadata must be refreshed externally
+type fwupdBackend struct{}
+
+func init() {
+	registerBackend(&fwupdBackend{})
+}
+
+func (b *fwupdBackend) Name() string { return "fwupd" }
+
+func (b *fwupdBackend) Detect(ctx context.Context, distro string) bool { return checkCmd("fwupdmgr") }
+
+func (b *fwupdBackend) Check(ctx context.Context) (api.UpdatesList, error) { return UpdateFwupd(ctx) }
+
+func (b *fwupdBackend) LogPaths() []string { return nil }
+
+func (b *fwupdBackend) ParseLog(ctx context.Context, fp string, f *api.File) error {
+	return fmt.Errorf("fwupd has no log file")
+}
+
+func (b *fwupdBackend) Capabilities() Capability { return CapOldVersion | CapRepo }
+
+// UpdateFwupd uses fwupdmgr to get available firmware updates
+func UpdateFwupd(ctx context.Context) (api.UpdatesList, error) {
+	raw, err := runCmd(ctx, "fwupdmgr", "get-updates", "--json")
+	if err != nil {
+		// 2 means there is nothing to do, no updatable devices or no updates
+		if exitError, ok := err.(*exec.ExitError); ok && exitError.ExitCode() == 2 {
+			return make(api.UpdatesList, 0), nil
+		}
+		return api.UpdatesList{}, err
+	}
+	return parseFwupdUpdates(raw)
+}
+
+// parseFwupdUpdates returns the newest release of each device, fwupd lists them newest first
+func parseFwupdUpdates(out string) (api.UpdatesList, error) {
+	updates := make(api.UpdatesList, 0)
+	var reply fwupdReply
+	if err := json.Unmarshal([]byte(out), &reply); err != nil {
+		return updates, fmt.Errorf("cannot unmarshal fwupdmgr output: %v", err)
+	}
+	for _, d := range reply.Devices {
+		if len(d.Releases) == 0 {
+			continue
+		}
+		r := d.Releases[0]
+		name := d.Name
+		if name == "" {
+			name = d.DeviceID
+		}
+		updates = append(updates, api.Update{
+			Pkg:      name,
+			OldVer:   d.Version,
+			NewVer:   r.Version,
+			Repo:     r.RemoteID,
+			Severity: r.Urgency,
+		})
+	}
+	return updates, nil
+}
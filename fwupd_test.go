@@ -0,0 +1,95 @@
+package main
+
+import (
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cosandr/go-check-updates/api"
+)
+
+func TestFwupdParseUpdates(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+	out := `{
+  "Devices" : [
+    {
+      "Name" : "XPS 13 9310",
+      "DeviceId" : "6d8a1ad4a0e5e8a1de1c2b4b3e5b3b4e0a7c2f1d",
+      "Guid" : [
+        "3a5bc8f1-7c2e-4a1b-9f0d-2e4c6a8b0d1f"
+      ],
+      "Version" : "2.2.0",
+      "Vendor" : "Dell Inc.",
+      "Flags" : [
+        "internal",
+        "updatable",
+        "needs-reboot"
+      ],
+      "Releases" : [
+        {
+          "AppstreamId" : "com.dell.uefi3a5bc8f1.firmware",
+          "RemoteId" : "lvfs",
+          "Name" : "XPS 13 9310 System Update",
+          "Version" : "2.4.1",
+          "Urgency" : "high",
+          "Size" : 25214432
+        },
+        {
+          "AppstreamId" : "com.dell.uefi3a5bc8f1.firmware",
+          "RemoteId" : "lvfs",
+          "Name" : "XPS 13 9310 System Update",
+          "Version" : "2.3.0",
+          "Urgency" : "medium"
+        }
+      ]
+    },
+    {
+      "Name" : "Samsung SSD 970 EVO Plus 1TB",
+      "DeviceId" : "71b677ca0f1bc2c5b804fa1d59e52064ce589293",
+      "Version" : "1B2QEXM7",
+      "Releases" : [
+        {
+          "RemoteId" : "lvfs-testing",
+          "Version" : "2B2QEXM7"
+        }
+      ]
+    },
+    {
+      "Name" : "TPM 2.0",
+      "DeviceId" : "c6a80ac3a22083423992a3cb15018989f37834d6",
+      "Version" : "7.2.1.0",
+      "Releases" : []
+    }
+  ]
+}`
+	actual, err := parseFwupdUpdates(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := api.UpdatesList{
+		{
+			Pkg:      "XPS 13 9310",
+			OldVer:   "2.2.0",
+			NewVer:   "2.4.1",
+			Repo:     "lvfs",
+			Severity: "high",
+		},
+		{
+			Pkg:    "Samsung SSD 970 EVO Plus 1TB",
+			OldVer: "1B2QEXM7",
+			NewVer: "2B2QEXM7",
+			Repo:   "lvfs-testing",
+		},
+	}
+	if len(actual) != len(expected) {
+		t.Fatalf("expected %d updates, got %d: %v", len(expected), len(actual), actual)
+	}
+	for i, a := range actual {
+		if e := expected[i]; !a.Equals(&e) || a.Severity != e.Severity {
+			t.Errorf("expected %v, got %v", e, a)
+		}
+	}
+	if _, err := parseFwupdUpdates("No updatable devices"); err == nil {
+		t.Errorf("expected error for invalid output")
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+/*
+$ emerge --pretend --update --deep --newuse --verbose --color=n @world
+
+These are the packages that would be merged, in order:
+
+Calculating dependencies... done!
+[ebuild     U  ] sys-libs/glibc-2.32-r3:2.2::gentoo [2.32-r2:2.2::gentoo] USE="multiarch ssp -audit" 17,685 KiB
+[ebuild     U  ] dev-lang/python-3.9.1:3.9::gentoo [3.9.0_p1:3.9::gentoo] USE="ncurses readline ssl" 18,469 KiB
+[ebuild  N     ] dev-libs/libfoo-1.0::gentoo  USE="-static-libs" 100 KiB
+
+$ cat /var/log/emerge.log
+1607163693:  >>> emerge (1 of 2) sys-libs/glibc-2.32-r3 to /
+1607163800:  ::: completed emerge (1 of 2) sys-libs/glibc-2.32-r3 to /
+1607163900:  >>> unmerge success: dev-libs/foo-1.0
+*/
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cosandr/go-check-updates/api"
+)
+
+// Group 1: flags (N, U, D, R, etc.)
+// Group 2: <category>/<name>-<version>[:slot][::repo]
+// Group 3: old version, same format without category and name (optional)
+var reEmerge = regexp.MustCompile(`(?m)^\[ebuild\s+([^\]]*)\]\s+(\S+)(?:\s+\[([^\]]+)\])?`)
+
+// Group 1: <category>/<name>
+// Group 2: version
+var reGentooCPV = regexp.MustCompile(`^(.+?)-(\d[^-]*(?:-r\d+)?)$`)
+
+// Group 1: unix timestamp
+// Group 2: message
+var reEmergeLog = regexp.MustCompile(`^(\d+):\s+(.*)$`)
+
+// Group 1: <category>/<name>-<version>
+var reEmergeLogCompleted = regexp.MustCompile(`^::: completed emerge \(\d+ of \d+\) (\S+) to `)
+
+// Group 1: <category>/<name>-<version>
+var reEmergeLogUnmerged = regexp.MustCompile(`^>>> unmerge success: (\S+)$`)
+
+// emergeBackend checks for updates to @world using emerge, the tree must be synced externally
+type emergeBackend struct {
+	logFp string
+}
+
+func init() {
+	registerBackend(&emergeBackend{logFp: "/var/log/emerge.log"})
+}
+
+func (b *emergeBackend) Name() string { return "emerge" }
+
+func (b *emergeBackend) Detect(ctx context.Context, distro string) bool { return distro == "gentoo" }
+
+func (b *emergeBackend) Check(ctx context.Context) (api.UpdatesList, error) { return UpdateEmerge(ctx) }
+
+func (b *emergeBackend) LogPaths() []string {
+	if b.logFp == "" {
+		return nil
+	}
+	return []string{b.logFp}
+}
+
+func (b *emergeBackend) ParseLog(ctx context.Context, fp string, f *api.File) error {
+	return checkEmergeLogs(fp, f)
+}
+
+func (b *emergeBackend) Capabilities() Capability { return CapOldVersion | CapRepo | CapLogs }
+
+// UpdateEmerge uses emerge in pretend mode to get available updates
+func UpdateEmerge(ctx context.Context) (api.UpdatesList, error) {
+	raw, err := runCmd(ctx, "emerge", "--pretend", "--update", "--deep", "--newuse", "--verbose", "--color=n", "@world")
+	if err != nil {
+		return api.UpdatesList{}, err
+	}
+	return parseEmergePretend(raw), nil
+}
+
+// splitGentooAtom splits <category>/<name>-<version>[:slot][::repo]
+func splitGentooAtom(atom string) (name, ver, slot, repo string) {
+	if i := strings.Index(atom, "::"); i >= 0 {
+		atom, repo = atom[:i], atom[i+2:]
+	}
+	if i := strings.Index(atom, ":"); i >= 0 {
+		atom, slot = atom[:i], atom[i+1:]
+	}
+	m := reGentooCPV.FindStringSubmatch(atom)
+	if m == nil {
+		return atom, "", slot, repo
+	}
+	return m[1], m[2], slot, repo
+}
+
+// parseEmergePretend returns upgrades and downgrades, new packages and rebuilds are ignored
+//
+// Slotted packages use <category>/<name>:<slot> as name
+func parseEmergePretend(out string) api.UpdatesList {
+	updates := make(api.UpdatesList, 0)
+	for _, m := range reEmerge.FindAllStringSubmatch(out, -1) {
+		if !strings.ContainsAny(m[1], "UD") {
+			continue
+		}
+		name, newVer, slot, repo := splitGentooAtom(m[2])
+		var oldVer string
+		if m[3] != "" {
+			oldVer = strings.SplitN(m[3], ":", 2)[0]
+		}
+		if slot != "" && slot != "0" {
+			name += ":" + slot
+		}
+		updates = append(updates, api.Update{
+			Pkg:    name,
+			OldVer: oldVer,
+			NewVer: newVer,
+			Repo:   repo,
+		})
+	}
+	return updates
+}
+
+// removeGentoo removes updates for name, ignoring slots, where match returns true
+func removeGentoo(f *api.File, name string, match func(u *api.Update) bool) bool {
+	updates := make(api.UpdatesList, 0, len(f.Updates))
+	for _, u := range f.Updates {
+		pkg := strings.SplitN(u.Pkg, ":", 2)[0]
+		if pkg == name && match(&u) {
+			continue
+		}
+		updates = append(updates, u)
+	}
+	changed := len(f.Updates) != len(updates)
+	f.Updates = updates
+	return changed
+}
+
+// checkEmergeLogs read emerge log file and update f accordingly
+func checkEmergeLogs(fp string, f *api.File) error {
+	file, err := os.Open(fp)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	lastChecked, err := time.Parse(time.RFC3339, f.Checked)
+	if err != nil {
+		return fmt.Errorf("cannot parse cached time '%s': %v", f.Checked, err)
+	}
+	beforeLen := len(f.Updates)
+	for scanner.Scan() {
+		m := reEmergeLog.FindStringSubmatch(scanner.Text())
+		if len(m) != 3 {
+			continue
+		}
+		sec, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil {
+			log.Debugf("checkEmergeLogs: cannot parse '%s': %v", m[1], err)
+			continue
+		}
+		if time.Unix(sec, 0).Before(lastChecked) {
+			continue
+		}
+		if c := reEmergeLogCompleted.FindStringSubmatch(m[2]); c != nil {
+			name, ver, _, _ := splitGentooAtom(c[1])
+			if changed := removeGentoo(f, name, func(u *api.Update) bool { return u.NewVer == ver }); changed {
+				log.Debugf("checkEmergeLogs: removed upgraded package %s %s", name, ver)
+			} else {
+				log.Debugf("checkEmergeLogs: skip emerged package %s %s", name, ver)
+			}
+		} else if c := reEmergeLogUnmerged.FindStringSubmatch(m[2]); c != nil {
+			// Old versions are unmerged during upgrades as well, only match installed version
+			name, ver, _, _ := splitGentooAtom(c[1])
+			if changed := removeGentoo(f, name, func(u *api.Update) bool { return u.OldVer == ver }); changed {
+				log.Debugf("checkEmergeLogs: removed uninstalled package %s %s", name, ver)
+			}
+		}
+	}
+	if len(f.Updates) != beforeLen {
+		log.Infof("checkEmergeLogs: %s: removed %d pending updates", fp, beforeLen-len(f.Updates))
+	}
+	return scanner.Err()
+}
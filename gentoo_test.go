@@ -0,0 +1,130 @@
+package main
+
+import (
+	"io/ioutil"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cosandr/go-check-updates/api"
+)
+
+func TestGentooParseEmergePretend(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+	out := `
+These are the packages that would be merged, in order:
+
+Calculating dependencies... done!
+[ebuild     U  ] sys-libs/glibc-2.32-r3:2.2::gentoo [2.32-r2:2.2::gentoo] USE="multiarch ssp -audit" 17,685 KiB
+[ebuild     U  ] dev-lang/python-3.9.1:3.9::gentoo [3.9.0_p1:3.9::gentoo] USE="ncurses readline ssl" PYTHON_TARGETS="python3_9" 18,469 KiB
+[ebuild  N     ] dev-libs/libfoo-1.0::gentoo  USE="-static-libs" 100 KiB
+[ebuild   R    ] app-editors/vim-8.2.0814-r100::gentoo  USE="X* -python" 0 KiB
+[ebuild     U  ] media-libs/x264-0.0.20201126::gentoo [0.0.20190214-r1::gentoo] USE="threads" 0 KiB
+[ebuild     UD ] x11-misc/picom-8.2::guru [9_pre20201123::guru] USE="-doc" 0 KiB
+[ebuild     U  ] dev-python/setuptools-scm-5.0.1::gentoo [4.1.2::gentoo] 0 KiB
+
+Total: 7 packages (5 upgrades, 1 downgrade, 1 new, 1 reinstall), Size of downloads: 36,154 KiB
+`
+	actual := parseEmergePretend(out)
+	expected := api.UpdatesList{
+		{
+			Pkg:    "sys-libs/glibc:2.2",
+			OldVer: "2.32-r2",
+			NewVer: "2.32-r3",
+			Repo:   "gentoo",
+		},
+		{
+			Pkg:    "dev-lang/python:3.9",
+			OldVer: "3.9.0_p1",
+			NewVer: "3.9.1",
+			Repo:   "gentoo",
+		},
+		{
+			Pkg:    "media-libs/x264",
+			OldVer: "0.0.20190214-r1",
+			NewVer: "0.0.20201126",
+			Repo:   "gentoo",
+		},
+		{
+			Pkg:    "x11-misc/picom",
+			OldVer: "9_pre20201123",
+			NewVer: "8.2",
+			Repo:   "guru",
+		},
+		{
+			Pkg:    "dev-python/setuptools-scm",
+			OldVer: "4.1.2",
+			NewVer: "5.0.1",
+			Repo:   "gentoo",
+		},
+	}
+	if len(actual) != len(expected) {
+		t.Fatalf("expected %d updates, got %d: %v", len(expected), len(actual), actual)
+	}
+	for i, a := range actual {
+		if e := expected[i]; !a.Equals(&e) {
+			t.Errorf("expected %v, got %v", e, a)
+		}
+	}
+}
+
+func TestGentooEmergeLogs(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+	cache = NewInternalCache()
+	cache.f.Checked = "2020-12-05T10:00:00Z"
+	cache.f.Updates = api.UpdatesList{
+		{
+			Pkg:    "sys-libs/glibc:2.2",
+			OldVer: "2.32-r2",
+			NewVer: "2.32-r3",
+		},
+		{
+			Pkg:    "dev-lang/python:3.9",
+			OldVer: "3.9.0_p1",
+			NewVer: "3.9.1",
+		},
+		{
+			Pkg:    "dev-lang/python:3.8",
+			OldVer: "3.8.6",
+			NewVer: "3.8.7",
+		},
+		{
+			Pkg:    "media-libs/x264",
+			OldVer: "0.0.20190214-r1",
+			NewVer: "0.0.20201126",
+		},
+		{
+			Pkg:    "dev-python/setuptools-scm",
+			OldVer: "4.1.2",
+			NewVer: "5.0.1",
+		},
+	}
+	file := `1607100000:  ::: completed emerge (1 of 1) dev-python/setuptools-scm-5.0.1 to /
+1607163600: Started emerge on: Dec 05, 2020 10:20:00
+1607163693:  >>> emerge (1 of 2) sys-libs/glibc-2.32-r3 to /
+1607163700:  === (1 of 2) Merging (sys-libs/glibc-2.32-r3::/var/db/repos/gentoo/sys-libs/glibc/glibc-2.32-r3.ebuild)
+1607163750:  >>> AUTOCLEAN: sys-libs/glibc:2.2
+1607163751:  === Unmerging... (sys-libs/glibc-2.32-r2)
+1607163752:  >>> unmerge success: sys-libs/glibc-2.32-r2
+1607163800:  ::: completed emerge (1 of 2) sys-libs/glibc-2.32-r3 to /
+1607163900:  ::: completed emerge (2 of 2) dev-lang/python-3.9.1 to /
+1607164000:  >>> unmerge success: media-libs/x264-0.0.20190214-r1
+1607164100:  >>> unmerge success: dev-python/setuptools-scm-4.0.0
+`
+	fp := "/tmp/emerge_test.log"
+	if err := ioutil.WriteFile(fp, []byte(file), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkEmergeLogs(fp, &cache.f); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"dev-lang/python:3.8", "dev-python/setuptools-scm"}
+	if len(cache.f.Updates) != len(expected) {
+		t.Fatalf("expected %v, got %d: %v", expected, len(cache.f.Updates), cache.f.Updates)
+	}
+	for i, u := range cache.f.Updates {
+		if u.Pkg != expected[i] {
+			t.Errorf("expected %s, got %s", expected[i], u.Pkg)
+		}
+	}
+}
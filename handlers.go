@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
@@ -26,6 +27,8 @@ var upgrader = websocket.Upgrader{}
 // - log_file: used with refresh, read package manager log
 // - every: used with refresh, time duration to wait between updates
 // - immediate: used with refresh, return response without waiting for update to finish
+// - change: used with updates, comma separated change levels to return, e.g. major,minor
+// - downgrade: used with updates, only return downgrades
 func HandleAPI(w http.ResponseWriter, r *http.Request) {
 	var start time.Time
 	log.Debugf("HandleAPI: GET - %s - %s", r.RemoteAddr, r.RequestURI)
@@ -55,7 +58,7 @@ func HandleAPI(w http.ResponseWriter, r *http.Request) {
 	if refresh {
 		if _, useLog := params["log_file"]; useLog {
 			log.Debug("HandleAPI: update from package manager log file")
-			if cache.f.Checked == "" {
+			if f := cache.Snapshot(); f.Checked == "" {
 				resp.Error = fmt.Sprintf("Updates were never checked, cannot update from logs.")
 				w.WriteHeader(http.StatusBadRequest)
 				return
@@ -86,15 +89,27 @@ func HandleAPI(w http.ResponseWriter, r *http.Request) {
 			if willRefresh {
 				log.Debug("HandleAPI: cache file refreshing")
 				if immediate {
-					go cache.Update()
-					log.Debug("HandleAPI: cache file update queued")
+					_, joined := cache.StartUpdate()
+					if joined {
+						log.Debug("HandleAPI: cache file update already in progress")
+						resp.Joined = &joined
+					} else {
+						log.Debug("HandleAPI: cache file update queued")
+					}
 					tmp := true
 					resp.Queued = &tmp
 					w.WriteHeader(http.StatusAccepted)
 				} else {
 					log.Debug("HandleAPI: cache file updating")
-					err := cache.Update()
-					if err != nil {
+					err := cache.Update(r.Context())
+					if isTimeout(err) {
+						log.Errorf("HandleAPI: update timed out: %v", err)
+						resp.Error = fmt.Sprintf("Timed out updating cache file: %v", err)
+						tmp := true
+						resp.Timeout = &tmp
+						w.WriteHeader(http.StatusGatewayTimeout)
+						return
+					} else if err != nil {
 						log.Errorf("HandleAPI: update failed: %v", err)
 						resp.Error = fmt.Sprintf("Cannot update cache file: %v", err)
 						w.WriteHeader(http.StatusInternalServerError)
@@ -118,6 +133,22 @@ func HandleAPI(w http.ResponseWriter, r *http.Request) {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
+		if val := params.Get("change"); val != "" {
+			levels := strings.Split(val, ",")
+			for _, l := range levels {
+				if !containsString(changeLevels, l) {
+					resp.Error = fmt.Sprintf("Unknown change level '%s', expected one of %s", l, strings.Join(changeLevels, ", "))
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+			}
+			log.Debugf("HandleAPI: filtering change levels %v", levels)
+			f.Updates = f.Updates.FilterChange(levels)
+		}
+		if _, downgrade := params["downgrade"]; downgrade {
+			log.Debug("HandleAPI: filtering downgrades")
+			f.Updates = f.Updates.Downgrades()
+		}
 		log.Debug("HandleAPI: setting response data to cache file content")
 		resp.Data = &f
 	}
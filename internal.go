@@ -1,9 +1,11 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"math/rand"
 	"os"
 	"sort"
 	"sync"
@@ -17,8 +19,10 @@ import (
 // NewInternalCache returns a pointer to a new InternalCache struct
 func NewInternalCache() *InternalCache {
 	return &InternalCache{
-		f:  api.File{},
-		ws: &WsFeed{listeners: make(map[uint16]chan struct{})},
+		ctx:     context.Background(),
+		f:       api.File{Version: api.FileVersion},
+		ws:      &WsFeed{listeners: make(map[uint16]chan struct{})},
+		restart: newRestartChecker(),
 	}
 }
 
@@ -54,13 +58,20 @@ func (f *WsFeed) remove(i uint16) {
 }
 
 // Broadcast wakes up all listeners
+//
+// Listeners which have not handled the previous broadcast yet are skipped,
+// they will read the latest snapshot once they do.
 func (f *WsFeed) Broadcast() {
 	f.L.Lock()
 	defer f.L.Unlock()
 	var empty struct{}
 	for idx, lis := range f.listeners {
-		log.Debugf("WsFeed.Broadcast: %d", idx)
-		lis <- empty
+		select {
+		case lis <- empty:
+			log.Debugf("WsFeed.Broadcast: %d", idx)
+		default:
+			log.Debugf("WsFeed.Broadcast: %d already pending", idx)
+		}
 	}
 }
 
@@ -79,92 +90,475 @@ func (f *WsFeed) Subscribe() *Subscription {
 	}
 }
 
+// refreshCall is a refresh in progress, callers joining it wait until done is closed
+type refreshCall struct {
+	started time.Time
+	done    chan struct{}
+	err     error
+	cancel  context.CancelFunc
+	// Number of callers waiting for the result, protected by InternalCache.mu
+	waiters int
+	// Set if the refresh was started in the background, it is then never cancelled by callers giving up
+	detached bool
+}
+
 // InternalCache stores information about the updates cache
 // Contains a WsFeed for threadsafe operations
+//
+// The file is protected by mu, readers should use Snapshot to get a consistent copy
 type InternalCache struct {
-	f          api.File
-	fp         string
-	logFp      string
-	logFunc    func(string) error
-	updateFunc func() (updates api.UpdatesList, err error)
-	ws         *WsFeed
+	// Lifetime of the program, refreshes are cancelled once it is done
+	ctx      context.Context
+	mu       sync.RWMutex
+	f        api.File
+	fileMu   sync.Mutex // Serializes writes to fp
+	fp       string
+	backends []Backend
+	ws       *WsFeed
+	restart  *restartChecker
+	timeouts backendTimeouts
+	// Data is stale if the last success is older than this, zero disables it
+	staleAfter time.Duration
+	// Refresh in progress, protected by mu
+	refreshing *refreshCall
+}
+
+// Snapshot returns a deep copy of the internal cache file
+func (ic *InternalCache) Snapshot() api.File {
+	ic.mu.RLock()
+	defer ic.mu.RUnlock()
+	return ic.copyFile()
+}
+
+// copyFile returns a deep copy of the internal cache file with the refresh state set, mu must be held
+func (ic *InternalCache) copyFile() api.File {
+	f := ic.f.Copy()
+	if ic.refreshing != nil {
+		f.Refreshing = true
+		f.RefreshStarted = ic.refreshing.started.Format(time.RFC3339)
+	}
+	f.Stale = ic.isStale()
+	return f
+}
+
+// isStale returns true if the last successful refresh is older than staleAfter, mu must be held
+func (ic *InternalCache) isStale() bool {
+	if ic.staleAfter <= 0 || ic.f.IsEmpty() {
+		return false
+	}
+	t, err := time.Parse(time.RFC3339, ic.f.LastSuccess)
+	if err != nil {
+		return true
+	}
+	return time.Since(t) > ic.staleAfter
 }
 
 // Update the internal cache and optional file
-func (ic *InternalCache) Update() error {
+//
+// If a refresh is already in progress, waits for it instead of starting another one.
+// Returns ctx.Err() if ctx is done first, the refresh is cancelled if no other callers are waiting for it.
+func (ic *InternalCache) Update(ctx context.Context) error {
+	call, _ := ic.startUpdate(false)
+	select {
+	case <-call.done:
+		return call.err
+	case <-ctx.Done():
+		ic.mu.Lock()
+		call.waiters--
+		if call.waiters == 0 && !call.detached {
+			log.Debugf("InternalCache.Update: %v, cancelling refresh", ctx.Err())
+			call.cancel()
+			// Callers arriving while it winds down start a new refresh instead of joining this one
+			ic.clearRefresh(call)
+		}
+		ic.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// StartUpdate starts a refresh in the background unless one is already in progress
+//
+// Returns the refresh in progress and true if it was already running.
+func (ic *InternalCache) StartUpdate() (*refreshCall, bool) {
+	return ic.startUpdate(true)
+}
+
+// startUpdate starts or joins a refresh, if detach is false the caller must wait for it
+func (ic *InternalCache) startUpdate(detach bool) (*refreshCall, bool) {
+	ic.mu.Lock()
+	call := ic.refreshing
+	joined := call != nil
+	if joined {
+		log.Debugf("InternalCache.startUpdate: joining refresh started at %s", call.started.Format(time.RFC3339))
+	} else {
+		var ctx context.Context
+		call = &refreshCall{started: time.Now(), done: make(chan struct{})}
+		ctx, call.cancel = context.WithCancel(ic.ctx)
+		ic.refreshing = call
+		go func() {
+			call.err = ic.update(ctx, call)
+			call.cancel()
+			close(call.done)
+		}()
+	}
+	if detach {
+		call.detached = true
+	} else {
+		call.waiters++
+	}
+	ic.mu.Unlock()
+	if !joined {
+		ic.ws.Broadcast()
+		log.Debug("InternalCache.startUpdate: WS broadcast")
+	}
+	return call, joined
+}
+
+// Wait blocks until the refresh in progress, if any, is done
+func (ic *InternalCache) Wait() {
+	ic.mu.RLock()
+	call := ic.refreshing
+	ic.mu.RUnlock()
+	if call != nil {
+		<-call.done
+	}
+}
+
+// clearRefresh ends call if it is still the refresh in progress, mu must be held
+//
+// A cancelled call is replaced by the next refresh before it returns, which must not be ended by it.
+func (ic *InternalCache) clearRefresh(call *refreshCall) {
+	if ic.refreshing == call {
+		ic.refreshing = nil
+	}
+}
+
+// endRefresh ends call without changing the cache
+func (ic *InternalCache) endRefresh(call *refreshCall) {
+	ic.mu.Lock()
+	ic.clearRefresh(call)
+	ic.mu.Unlock()
+	ic.ws.Broadcast()
+	log.Debug("InternalCache.endRefresh: WS broadcast")
+}
+
+// update checks all backends and stores the result, ending call
+//
+// All backends are checked in parallel, previous updates are kept for backends
+// which failed without returning anything. The cache is only locked once the check is done,
+// ctx is checked again with the lock held since callers cancel it with the lock held.
+func (ic *InternalCache) update(ctx context.Context, call *refreshCall) error {
 	log.Info("refreshing")
-	updates, err := ic.updateFunc()
+	updates, errs, timedOut := checkBackends(ctx, ic.backends, ic.timeouts)
+	// Cancelled, keep the cache as it is
+	if ctx.Err() != nil {
+		ic.endRefresh(call)
+		return ctx.Err()
+	}
+	err := joinErrors(errs)
+	if len(timedOut) > 0 {
+		err = &timeoutError{err: err, backends: timedOut}
+	}
 	if err != nil {
-		// Something failed and we got nothing
-		if len(updates) == 0 {
+		// Everything failed and we got nothing
+		if len(errs) == len(ic.backends) && len(updates) == 0 {
+			ic.mu.Lock()
+			if ctx.Err() != nil {
+				ic.mu.Unlock()
+				ic.endRefresh(call)
+				return ctx.Err()
+			}
+			ic.setStatus(err)
+			ic.clearRefresh(call)
+			f := ic.f.Copy()
+			ic.mu.Unlock()
+			if ic.fp != "" {
+				if wErr := ic.write(&f); wErr != nil {
+					log.Errorf("InternalCache.Update: %v", wErr)
+				}
+			}
+			ic.ws.Broadcast()
+			log.Debug("InternalCache.Update: WS broadcast")
 			return err
 		}
 		// Partial failure, continue
 		log.Error(err)
 	}
-	sort.Slice(updates, func(i, j int) bool {
-		return updates[i].Pkg < updates[j].Pkg
-	})
+	reboot, services := ic.checkRestart(ctx)
+	ic.mu.Lock()
+	if ctx.Err() != nil {
+		ic.mu.Unlock()
+		ic.endRefresh(call)
+		return ctx.Err()
+	}
+	found := make(map[string]bool)
+	for _, u := range updates {
+		found[u.Backend] = true
+	}
+	for _, u := range ic.f.Updates {
+		if _, failed := errs[u.Backend]; failed && !found[u.Backend] {
+			updates = append(updates, u)
+		}
+	}
+	sortUpdates(updates)
 	ic.f.Updates = updates
+	ic.f.Errors = errs
 	ic.f.Checked = time.Now().Format(time.RFC3339)
+	ic.f.RebootRequired, ic.f.ServicesToRestart = reboot, services
+	ic.setStatus(err)
+	ic.clearRefresh(call)
+	f := ic.f.Copy()
+	ic.mu.Unlock()
 	if ic.fp != "" {
-		err = ic.Write()
+		if wErr := ic.write(&f); wErr != nil {
+			err = wErr
+		}
 	}
 	ic.ws.Broadcast()
 	log.Debug("InternalCache.Update: WS broadcast")
 	return err
 }
 
-// RefreshFromLogs updates cache by reading package manager logs
+// sortUpdates sorts updates by package name, backend and architecture
+func sortUpdates(updates api.UpdatesList) {
+	sort.Slice(updates, func(i, j int) bool {
+		if updates[i].Pkg == updates[j].Pkg {
+			if updates[i].Backend == updates[j].Backend {
+				return updates[i].Arch < updates[j].Arch
+			}
+			return updates[i].Backend < updates[j].Backend
+		}
+		return updates[i].Pkg < updates[j].Pkg
+	})
+}
+
+// setStatus records the result of a refresh, mu must be held
+func (ic *InternalCache) setStatus(err error) {
+	if err != nil {
+		ic.f.LastError = err.Error()
+		ic.f.ConsecutiveFailures++
+		return
+	}
+	ic.f.LastSuccess = time.Now().Format(time.RFC3339)
+	ic.f.LastError = ""
+	ic.f.ConsecutiveFailures = 0
+}
+
+// RefreshFromLogs updates cache by reading the logs of all backends which support it
 func (ic *InternalCache) RefreshFromLogs() error {
-	if ic.logFp == "" {
+	errs := make(map[string]string)
+	var parsed int
+	changed := false
+	for _, b := range ic.backends {
+		for _, logFp := range b.LogPaths() {
+			removed, err := ic.parseLog(ic.ctx, b, logFp)
+			if err != nil {
+				errs[b.Name()] = err.Error()
+				continue
+			}
+			parsed++
+			changed = changed || removed
+		}
+	}
+	if parsed == 0 && len(errs) == 0 {
 		return fmt.Errorf("InternalCache.RefreshFromLogs: no package manager log file path")
 	}
-	if err := ic.logFunc(ic.logFp); err != nil {
-		return err
+	// Nothing was upgraded or removed, a restart is not needed more than before
+	if changed {
+		ic.setRestart(ic.checkRestart(ic.ctx))
+		ic.ws.Broadcast()
+		log.Debug("InternalCache.RefreshFromLogs: WS broadcast")
+	}
+	return joinErrors(errs)
+}
+
+// parseLog calls ParseLog of b with a copy of the updates found by b and removes the ones it dropped
+//
+// Log parsers match packages by name, this keeps them from removing
+// updates of other backends which happen to have the same name.
+// Updates without a backend, from files written by older versions, are passed to all of them.
+// The log is parsed without holding mu, updates replaced by a refresh in the meantime are left alone.
+// Returns true if any updates were removed.
+func (ic *InternalCache) parseLog(ctx context.Context, b Backend, fp string) (bool, error) {
+	ic.mu.RLock()
+	f := ic.f.Copy()
+	ic.mu.RUnlock()
+	own := make(api.UpdatesList, 0)
+	for _, u := range f.Updates {
+		if u.Backend == b.Name() || u.Backend == "" {
+			own = append(own, u)
+		}
+	}
+	f.Updates = own.Copy()
+	if err := b.ParseLog(ctx, fp, &f); err != nil {
+		return false, err
+	}
+	removed := make(api.UpdatesList, 0)
+	for _, u := range own {
+		if !f.Updates.Contains(u) {
+			removed = append(removed, u)
+		}
+	}
+	if len(removed) == 0 {
+		return false, nil
+	}
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	updates := make(api.UpdatesList, 0, len(ic.f.Updates))
+	for _, u := range ic.f.Updates {
+		if !removed.Contains(u) {
+			updates = append(updates, u)
+		}
+	}
+	ic.f.Updates = updates
+	return true, nil
+}
+
+// checkRestart returns whether a reboot is required and which services should be restarted
+func (ic *InternalCache) checkRestart(ctx context.Context) (bool, []string) {
+	if ic.restart == nil {
+		return false, nil
+	}
+	reboot, services := ic.restart.Check(ctx)
+	log.Debugf("InternalCache.checkRestart: reboot %v, %d services", reboot, len(services))
+	return reboot, services
+}
+
+// setRestart sets whether a reboot is required and which services should be restarted
+func (ic *InternalCache) setRestart(reboot bool, services []string) {
+	if ic.restart == nil {
+		return
+	}
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	ic.f.RebootRequired, ic.f.ServicesToRestart = reboot, services
+}
+
+// AutoRefresh refreshes the cache according to the interval until ic.ctx is done
+//
+// Failed refreshes are retried with exponential backoff starting at retryDelay,
+// until the next retry would be after the next scheduled refresh.
+func (ic *InternalCache) AutoRefresh(interval time.Duration, retryDelay time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	next := time.Now().Add(interval)
+	var retry <-chan time.Time
+	// scheduleRetry sets retry if the last refresh failed
+	scheduleRetry := func() {
+		retry = nil
+		ic.mu.RLock()
+		failures := ic.f.ConsecutiveFailures
+		ic.mu.RUnlock()
+		if failures == 0 || retryDelay <= 0 {
+			return
+		}
+		delay := backoffDelay(retryDelay, failures-1)
+		if time.Now().Add(delay).After(next) {
+			log.Infof("InternalCache.AutoRefresh: %d failures, not retrying before next refresh", failures)
+			return
+		}
+		log.Infof("InternalCache.AutoRefresh: %d failures, retrying in %v", failures, delay.Round(time.Second))
+		retry = time.After(delay)
+	}
+	refresh := func() {
+		if err := ic.Update(ic.ctx); err != nil {
+			log.Error(err)
+		}
+		scheduleRetry()
+	}
+	// Previous refresh may have failed already
+	scheduleRetry()
+	for {
+		select {
+		case <-ticker.C:
+			log.Debug("InternalCache.AutoRefresh: ticker")
+			next = time.Now().Add(interval)
+			refresh()
+		case <-retry:
+			log.Debug("InternalCache.AutoRefresh: retry")
+			refresh()
+		case <-ic.ctx.Done():
+			return
+		}
 	}
-	ic.ws.Broadcast()
-	log.Debug("InternalCache.RefreshFromLogs: WS broadcast")
-	return nil
 }
 
-// WatchLogs checks the package manager log according to the interval
-// and calls RefreshFromLogs if the file changed.
+// backoffDelay returns base doubled n times, with up to half of it removed at random
+func backoffDelay(base time.Duration, n int) time.Duration {
+	// Avoid overflows, that's over 2 years with 1 second
+	if n > 25 {
+		n = 25
+	}
+	d := base << uint(n)
+	return d - time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// LogPaths returns the log file paths of all backends which support it
+func (ic *InternalCache) LogPaths() []string {
+	ret := make([]string, 0)
+	for _, b := range ic.backends {
+		ret = append(ret, b.LogPaths()...)
+	}
+	return ret
+}
+
+// WatchLogs checks the package manager logs according to the interval until ic.ctx is done
+// and calls ParseLog of the matching backend if a file changed.
 func (ic *InternalCache) WatchLogs(interval time.Duration) {
-	var last time.Time
+	last := make(map[string]time.Time)
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 	for {
 		select {
 		case <-ticker.C:
-			info, err := os.Stat(ic.logFp)
+			if ic.parseChangedLogs(last) {
+				ic.setRestart(ic.checkRestart(ic.ctx))
+				ic.ws.Broadcast()
+				log.Debug("InternalCache.WatchLogs: WS broadcast")
+			}
+		case <-ic.ctx.Done():
+			return
+		}
+	}
+}
+
+// parseChangedLogs calls ParseLog for log files modified since last, returns true if any updates were removed
+func (ic *InternalCache) parseChangedLogs(last map[string]time.Time) bool {
+	changed := false
+	for _, b := range ic.backends {
+		for _, logFp := range b.LogPaths() {
+			info, err := os.Stat(logFp)
 			if err != nil {
 				log.Errorf("InternalCache.WatchLogs: %v", err)
 				continue
 			}
-			if info.ModTime().Equal(last) {
-				log.Debugf("InternalCache.WatchLogs: %s modified time unchanged", ic.logFp)
+			if info.ModTime().Equal(last[logFp]) {
+				log.Debugf("InternalCache.WatchLogs: %s modified time unchanged", logFp)
 				continue
 			}
-			last = info.ModTime()
-			err = ic.RefreshFromLogs()
+			last[logFp] = info.ModTime()
+			removed, err := ic.parseLog(ic.ctx, b, logFp)
 			if err != nil {
-				log.Error(err)
+				log.Errorf("InternalCache.WatchLogs: %s: %v", b.Name(), err)
+				continue
 			}
+			changed = changed || removed
 		}
 	}
+	return changed
 }
 
 // GetFile returns a copy of internal cache file
 // If there it is empty, attempts to read it from disk
 func (ic *InternalCache) GetFile() (api.File, error) {
-	// log.Debugf("InternalCache.GetFile: %v", ic.f)
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
 	if ic.f.IsEmpty() {
-		if ic.fp != "" {
+		if ic.fp == "" {
 			return api.File{}, fmt.Errorf("cache is empty and cache file is disabled")
 		} else if checkFileRead(ic.fp) {
 			log.Debugf("InternalCache.GetFile, cache empty, reading from %s", ic.fp)
-			err := ic.Read()
+			err := ic.read()
 			if err != nil {
 				return api.File{}, fmt.Errorf("cache is empty and cache file cannot be read: %v", err)
 			}
@@ -172,20 +566,23 @@ func (ic *InternalCache) GetFile() (api.File, error) {
 			return api.File{}, fmt.Errorf("cache is empty and no cache file was found")
 		}
 	}
-	return ic.f.Copy(), nil
+	return ic.copyFile(), nil
 }
 
 // NeedsUpdate returns true if the cache needs updating according to the update interval
 //
 // Malformed files are considered invalid and will be replaced
 func (ic *InternalCache) NeedsUpdate(interval time.Duration) bool {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
 	if ic.f.IsEmpty() {
 		if ic.fp == "" {
 			return true
 		}
-		err := ic.Read()
+		err := ic.read()
 		// Cannot read, update
 		if err != nil {
+			log.Warnf("InternalCache.NeedsUpdate: cannot read %s: %v", ic.fp, err)
 			return true
 		}
 	}
@@ -203,6 +600,15 @@ func (ic *InternalCache) NeedsUpdate(interval time.Duration) bool {
 
 // Read file to internal cache
 func (ic *InternalCache) Read() error {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	return ic.read()
+}
+
+// read file to internal cache, mu must be held
+//
+// Files written by older versions are migrated to the current format.
+func (ic *InternalCache) read() error {
 	if ic.fp == "" {
 		return fmt.Errorf("cache file disabled")
 	}
@@ -210,19 +616,42 @@ func (ic *InternalCache) Read() error {
 	if err != nil {
 		return err
 	}
-	return json.Unmarshal(bytes, &ic.f)
+	var f api.File
+	if err := json.Unmarshal(bytes, &f); err != nil {
+		return err
+	}
+	if f.Version != api.FileVersion {
+		log.Infof("InternalCache.read: migrating %s from version %d to %d", ic.fp, f.Version, api.FileVersion)
+	}
+	if err := f.Migrate(); err != nil {
+		return err
+	}
+	ic.f = f
+	return nil
 }
 
 // Write internal cache to file
 func (ic *InternalCache) Write() error {
+	ic.mu.RLock()
+	f := ic.f.Copy()
+	ic.mu.RUnlock()
+	return ic.write(&f)
+}
+
+// write f to the cache file, without the fields which are only set in API responses
+func (ic *InternalCache) write(f *api.File) error {
 	if ic.fp == "" {
 		return fmt.Errorf("cache file disabled")
 	}
 	log.Debug("InternalCache.Write: marshal file")
-	bytes, err := json.Marshal(&ic.f)
+	f.Version = api.FileVersion
+	f.Refreshing, f.RefreshStarted, f.Stale = false, "", false
+	bytes, err := json.Marshal(f)
 	if err != nil {
 		return err
 	}
+	ic.fileMu.Lock()
+	defer ic.fileMu.Unlock()
 	log.Debugf("InternalCache.Write: write file %s", ic.fp)
-	return ioutil.WriteFile(ic.fp, bytes, 0644)
+	return writeFileAtomic(ic.fp, bytes, 0644)
 }
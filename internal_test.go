@@ -1,23 +1,681 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/gorilla/websocket"
 	log "github.com/sirupsen/logrus"
 
 	"github.com/cosandr/go-check-updates/api"
 )
 
+// fakeBackend returns preset updates and removes every pending update when its log is parsed
+type fakeBackend struct {
+	name     string
+	updates  api.UpdatesList
+	err      error
+	logFp    string
+	optional bool
+	// Number of checks, Check blocks until wait is closed or ctx is done if set
+	calls int32
+	wait  chan struct{}
+	// Ignore ctx while waiting
+	ignoreCtx bool
+	// Number of checks which fail before returning updates
+	failures int32
+}
+
+func (b *fakeBackend) Name() string { return b.name }
+
+func (b *fakeBackend) Detect(ctx context.Context, distro string) bool { return distro == "fake" }
+
+func (b *fakeBackend) Check(ctx context.Context) (api.UpdatesList, error) {
+	atomic.AddInt32(&b.calls, 1)
+	if b.wait != nil && b.ignoreCtx {
+		<-b.wait
+	} else if b.wait != nil {
+		select {
+		case <-b.wait:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	if atomic.AddInt32(&b.failures, -1) >= 0 {
+		return nil, errors.New("temporary failure")
+	}
+	return b.updates.Copy(), b.err
+}
+
+func (b *fakeBackend) LogPaths() []string {
+	if b.logFp == "" {
+		return nil
+	}
+	return []string{b.logFp}
+}
+
+func (b *fakeBackend) ParseLog(ctx context.Context, fp string, f *api.File) error {
+	f.Updates = make(api.UpdatesList, 0)
+	return nil
+}
+
+func (b *fakeBackend) Capabilities() Capability { return CapOldVersion | CapRepo }
+
+func (b *fakeBackend) Optional() bool { return b.optional }
+
+// setTestFile replaces the updates and checked timestamp of ic while holding its lock
+func setTestFile(ic *InternalCache, updates api.UpdatesList, checked string) {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	ic.f.Updates = updates.Copy()
+	ic.f.Checked = checked
+}
+
+func TestUpdateBackend(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+	cache = NewInternalCache()
+	backend := &fakeBackend{
+		name: "fake",
+		updates: api.UpdatesList{
+			{
+				Pkg:    "zlib",
+				OldVer: "1.2.11-3",
+				NewVer: "1.2.11-4",
+			},
+			{
+				Pkg:    "bash",
+				OldVer: "5.0.018-1",
+				NewVer: "5.0.018-2",
+			},
+		},
+	}
+	cache.backends = []Backend{backend}
+	if err := cache.Update(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(cache.f.Updates) != 2 {
+		t.Fatalf("Expected 2 updates, got %d: %v", len(cache.f.Updates), cache.f.Updates)
+	}
+	if cache.f.Updates[0].Pkg != "bash" {
+		t.Errorf("Expected updates sorted by name, got %v", cache.f.Updates)
+	}
+	if cache.f.Updates[0].Backend != "fake" {
+		t.Errorf("Expected backend fake, got %s", cache.f.Updates[0].Backend)
+	}
+	if cache.f.Checked == "" {
+		t.Error("Expected checked timestamp to be set")
+	}
+	// Partial failure keeps updates
+	backend.err = errors.New("partial failure")
+	if err := cache.Update(context.Background()); err == nil {
+		t.Error("Expected error on partial failure")
+	}
+	if len(cache.f.Updates) != 2 {
+		t.Errorf("Expected 2 updates, got %d: %v", len(cache.f.Updates), cache.f.Updates)
+	}
+	// Nothing returned, cache untouched
+	backend.updates = nil
+	backend.err = errors.New("total failure")
+	if err := cache.Update(context.Background()); err == nil {
+		t.Error("Expected error on total failure")
+	}
+	if len(cache.f.Updates) != 2 {
+		t.Errorf("Expected 2 updates, got %d: %v", len(cache.f.Updates), cache.f.Updates)
+	}
+	// No log file
+	if err := cache.RefreshFromLogs(); err == nil {
+		t.Error("Expected error without log file")
+	}
+	backend.logFp = "/tmp/test_fake.log"
+	if err := cache.RefreshFromLogs(); err != nil {
+		t.Fatal(err)
+	}
+	if len(cache.f.Updates) != 0 {
+		t.Errorf("Expected 0 updates, got %d: %v", len(cache.f.Updates), cache.f.Updates)
+	}
+}
+
+func TestParseLogOwnUpdates(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+	cache = NewInternalCache()
+	cache.restart = nil
+	cache.backends = []Backend{
+		&fakeBackend{name: "pacman", logFp: "/tmp/test_fake.log"},
+		&fakeBackend{name: "pipx"},
+	}
+	setTestFile(cache, api.UpdatesList{
+		{Pkg: "black", OldVer: "20.8b0", NewVer: "20.8b1", Backend: "pipx"},
+		{Pkg: "black", OldVer: "20.8b0-1", NewVer: "20.8b1-1", Backend: "pacman"},
+		{Pkg: "zlib", OldVer: "1.2.11-3", NewVer: "1.2.11-4", Backend: "pacman"},
+	}, "2021-01-01T00:00:00Z")
+	if err := cache.RefreshFromLogs(); err != nil {
+		t.Fatal(err)
+	}
+	if len(cache.f.Updates) != 1 || cache.f.Updates[0].Backend != "pipx" {
+		t.Errorf("Expected only the pipx update, got %v", cache.f.Updates)
+	}
+}
+
+func TestUpdateCoalesce(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+	cache = NewInternalCache()
+	cache.restart = nil
+	backend := &fakeBackend{
+		name:    "fake",
+		updates: api.UpdatesList{{Pkg: "bash", OldVer: "5.0.018-1", NewVer: "5.0.018-2"}},
+		wait:    make(chan struct{}),
+	}
+	cache.backends = []Backend{backend}
+	sub := cache.ws.Subscribe()
+	defer sub.Unsubscribe()
+	first, joined := cache.StartUpdate()
+	if joined {
+		t.Error("Expected first refresh to start")
+	}
+	<-sub.ch
+	f := cache.Snapshot()
+	if !f.Refreshing || f.RefreshStarted == "" {
+		t.Errorf("Expected refresh in progress, got refreshing %v started '%s'", f.Refreshing, f.RefreshStarted)
+	}
+	// Concurrent callers join the refresh in progress
+	var wg sync.WaitGroup
+	errs := make([]error, 3)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = cache.Update(context.Background())
+		}(i)
+	}
+	second, joined := cache.StartUpdate()
+	if !joined || second != first {
+		t.Error("Expected immediate refresh to join the one in progress")
+	}
+	// Let the callers join before finishing
+	time.Sleep(100 * time.Millisecond)
+	close(backend.wait)
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	if n := atomic.LoadInt32(&backend.calls); n != 1 {
+		t.Errorf("Expected 1 check, got %d", n)
+	}
+	f = cache.Snapshot()
+	if f.Refreshing || f.RefreshStarted != "" || len(f.Updates) != 1 {
+		t.Errorf("Expected finished refresh with 1 update, got refreshing %v started '%s': %v", f.Refreshing, f.RefreshStarted, f.Updates)
+	}
+	// Next refresh runs again
+	if err := cache.Update(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&backend.calls); n != 2 {
+		t.Errorf("Expected 2 checks, got %d", n)
+	}
+}
+
+func TestUpdateTimeout(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+	cache = NewInternalCache()
+	cache.restart = nil
+	fast := &fakeBackend{name: "fast", updates: api.UpdatesList{{Pkg: "bash", NewVer: "5.0.018-2"}}}
+	slow := &fakeBackend{name: "slow", updates: api.UpdatesList{{Pkg: "zlib", NewVer: "1.2.11-4"}}, wait: make(chan struct{})}
+	defer close(slow.wait)
+	// Doesn't stop when cancelled
+	stuck := &fakeBackend{name: "stuck", wait: make(chan struct{}), ignoreCtx: true}
+	defer close(stuck.wait)
+	cache.backends = []Backend{fast, slow, stuck}
+	cache.timeouts = backendTimeouts{def: 100 * time.Millisecond, byName: map[string]time.Duration{"fast": time.Minute}}
+	start := time.Now()
+	err := cache.Update(context.Background())
+	if !isTimeout(err) {
+		t.Fatalf("Expected timeout error, got %v", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("Expected refresh to end after the timeout, took %v", d)
+	}
+	if backends := err.(*timeoutError).backends; len(backends) != 2 || backends[0] != "slow" || backends[1] != "stuck" {
+		t.Errorf("Expected slow and stuck to time out, got %v", backends)
+	}
+	f := cache.Snapshot()
+	if len(f.Updates) != 1 || f.Updates[0].Pkg != "bash" {
+		t.Errorf("Expected update from fast backend, got %v", f.Updates)
+	}
+	if msg := f.Errors["slow"]; !strings.Contains(msg, "timed out") {
+		t.Errorf("Expected timeout error for slow backend, got '%s'", msg)
+	}
+	// Other errors aren't timeouts
+	if isTimeout(errors.New("failed")) || isTimeout(context.Canceled) {
+		t.Error("Expected generic errors not to be timeouts")
+	}
+	// API reports timeouts separately
+	rec := httptest.NewRecorder()
+	HandleAPI(rec, httptest.NewRequest(http.MethodGet, "/api?refresh", nil))
+	var resp api.Response
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusGatewayTimeout || resp.Timeout == nil || !*resp.Timeout {
+		t.Errorf("Expected timeout response, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestUpdateCancel(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+	cache = NewInternalCache()
+	cache.restart = nil
+	backend := &fakeBackend{name: "fake", updates: api.UpdatesList{{Pkg: "bash", NewVer: "5.0.018-2"}}, wait: make(chan struct{})}
+	defer close(backend.wait)
+	cache.backends = []Backend{backend}
+	lifetime, stop := context.WithCancel(context.Background())
+	defer stop()
+	cache.ctx = lifetime
+	// Only caller gives up, refresh is cancelled
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := cache.Update(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Expected deadline exceeded, got %v", err)
+	}
+	cache.Wait()
+	if f := cache.Snapshot(); f.Refreshing || len(f.Updates) != 0 {
+		t.Errorf("Expected cancelled refresh without updates, got refreshing %v: %v", f.Refreshing, f.Updates)
+	}
+	// Background refresh isn't cancelled when a caller joining it gives up
+	cache.StartUpdate()
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := cache.Update(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Expected deadline exceeded, got %v", err)
+	}
+	if f := cache.Snapshot(); !f.Refreshing {
+		t.Error("Expected background refresh to continue")
+	}
+	// Program shutdown cancels everything
+	stop()
+	cache.Wait()
+	if err := cache.Update(context.Background()); err != context.Canceled {
+		t.Errorf("Expected cancelled refresh, got %v", err)
+	}
+}
+
+func TestUpdateAfterCancel(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+	cache = NewInternalCache()
+	cache.restart = nil
+	// Keeps the cancelled refresh running until wait is closed
+	backend := &fakeBackend{name: "fake", updates: api.UpdatesList{{Pkg: "bash", NewVer: "5.0.018-2"}}, wait: make(chan struct{}), ignoreCtx: true}
+	cache.backends = []Backend{backend}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := cache.Update(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Expected deadline exceeded, got %v", err)
+	}
+	// Next caller starts a new refresh instead of joining the cancelled one
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- cache.Update(context.Background())
+	}()
+	time.Sleep(100 * time.Millisecond)
+	close(backend.wait)
+	if err := <-errCh; err != nil {
+		t.Errorf("Expected refresh to succeed, got %v", err)
+	}
+	if n := atomic.LoadInt32(&backend.calls); n != 2 {
+		t.Errorf("Expected 2 checks, got %d", n)
+	}
+	cache.Wait()
+	if f := cache.Snapshot(); f.Refreshing || len(f.Updates) != 1 {
+		t.Errorf("Expected finished refresh with 1 update, got refreshing %v: %v", f.Refreshing, f.Updates)
+	}
+}
+
+func TestUpdateStatus(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+	cache = NewInternalCache()
+	cache.restart = nil
+	cache.staleAfter = time.Hour
+	backend := &fakeBackend{name: "fake", updates: api.UpdatesList{{Pkg: "bash", NewVer: "5.0.018-2"}}, failures: 2}
+	cache.backends = []Backend{backend}
+	for i := 1; i <= 2; i++ {
+		if err := cache.Update(context.Background()); err == nil {
+			t.Fatal("Expected error")
+		}
+		f := cache.Snapshot()
+		if f.ConsecutiveFailures != i || f.LastError == "" || f.LastSuccess != "" {
+			t.Errorf("Expected %d failures, got %d, last error '%s', last success '%s'", i, f.ConsecutiveFailures, f.LastError, f.LastSuccess)
+		}
+	}
+	if err := cache.Update(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	f := cache.Snapshot()
+	if f.ConsecutiveFailures != 0 || f.LastError != "" || f.LastSuccess == "" || f.Stale {
+		t.Errorf("Expected fresh success, got %d failures, last error '%s', last success '%s', stale %v",
+			f.ConsecutiveFailures, f.LastError, f.LastSuccess, f.Stale)
+	}
+	// Old success
+	cache.mu.Lock()
+	cache.f.LastSuccess = time.Now().Add(-2 * time.Hour).Format(time.RFC3339)
+	cache.mu.Unlock()
+	if f := cache.Snapshot(); !f.Stale {
+		t.Error("Expected stale data")
+	}
+	// Never succeeded
+	cache.mu.Lock()
+	cache.f.LastSuccess = ""
+	cache.mu.Unlock()
+	if f := cache.Snapshot(); !f.Stale {
+		t.Error("Expected stale data without last success")
+	}
+}
+
+func TestReadWrite(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+	tmp, err := ioutil.TempDir("", "test_cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	cache = NewInternalCache()
+	cache.fp = filepath.Join(tmp, "cache.json")
+	// Written before the file had a version
+	writeTestFile(t, cache.fp, `{"checked":"2020-06-01T23:10:23+02:00","updates":[{"pkg":"archiso","oldVer":"43-2","newVer":"44-2","repo":"pacman"}]}`)
+	if err := cache.Read(); err != nil {
+		t.Fatal(err)
+	}
+	f := cache.Snapshot()
+	if f.Version != api.FileVersion || f.LastSuccess != f.Checked || len(f.Updates) != 1 {
+		t.Errorf("Expected migrated file, got version %d, last success '%s': %v", f.Version, f.LastSuccess, f.Updates)
+	}
+	// Old failed refresh without a success isn't turned into one
+	writeTestFile(t, cache.fp, `{"checked":"2020-06-01T23:10:23+02:00","updates":[],"lastError":"failed","consecutiveFailures":1}`)
+	if err := cache.Read(); err != nil {
+		t.Fatal(err)
+	}
+	if f := cache.Snapshot(); f.LastSuccess != "" {
+		t.Errorf("Expected no last success, got '%s'", f.LastSuccess)
+	}
+	// Written by a newer version
+	writeTestFile(t, cache.fp, fmt.Sprintf(`{"version":%d,"checked":"2020-06-01T23:10:23+02:00","updates":[]}`, api.FileVersion+1))
+	if err := cache.Read(); err == nil {
+		t.Error("Expected error for newer version")
+	}
+	// Truncated by a crash
+	writeTestFile(t, cache.fp, `{"checked":"2020-06-01T23:10:23+02:00","upd`)
+	if err := cache.Read(); err == nil {
+		t.Error("Expected error for truncated file")
+	}
+	// Written with the current version
+	cache.mu.Lock()
+	cache.f = api.File{Version: api.FileVersion, Checked: "2020-06-02T13:28:16+02:00", Updates: api.UpdatesList{{Pkg: "bash", NewVer: "5.0.018-2"}}}
+	cache.mu.Unlock()
+	if err := cache.Write(); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := ioutil.ReadFile(cache.fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var written api.File
+	if err := json.Unmarshal(raw, &written); err != nil {
+		t.Fatal(err)
+	}
+	if written.Version != api.FileVersion || len(written.Updates) != 1 {
+		t.Errorf("Expected version %d with 1 update, got %s", api.FileVersion, raw)
+	}
+	// Refresh state is only part of API responses
+	f = cache.Snapshot()
+	f.Refreshing, f.RefreshStarted, f.Stale = true, "2020-06-02T13:30:00+02:00", true
+	if err := cache.write(&f); err != nil {
+		t.Fatal(err)
+	}
+	if raw, err = ioutil.ReadFile(cache.fp); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"refreshing", "refreshStarted", "stale"} {
+		if strings.Contains(string(raw), `"`+key+`"`) {
+			t.Errorf("Expected no %s in file, got %s", key, raw)
+		}
+	}
+}
+
+func TestAutoRefresh(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+	cache = NewInternalCache()
+	cache.restart = nil
+	lifetime, stop := context.WithCancel(context.Background())
+	defer stop()
+	cache.ctx = lifetime
+	backend := &fakeBackend{name: "fake", updates: api.UpdatesList{{Pkg: "bash", NewVer: "5.0.018-2"}}, failures: 3}
+	cache.backends = []Backend{backend}
+	if err := cache.Update(context.Background()); err == nil {
+		t.Fatal("Expected error")
+	}
+	go cache.AutoRefresh(time.Hour, 20*time.Millisecond)
+	for i := 0; i < 100; i++ {
+		if f := cache.Snapshot(); f.ConsecutiveFailures == 0 {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	f := cache.Snapshot()
+	if f.ConsecutiveFailures != 0 || len(f.Updates) != 1 {
+		t.Errorf("Expected retries to succeed, got %d failures: %v", f.ConsecutiveFailures, f.Updates)
+	}
+	if n := atomic.LoadInt32(&backend.calls); n != 4 {
+		t.Errorf("Expected 4 checks, got %d", n)
+	}
+	// Not retried past the next refresh
+	stop()
+	cache = NewInternalCache()
+	cache.restart = nil
+	lifetime, stop = context.WithCancel(context.Background())
+	defer stop()
+	cache.ctx = lifetime
+	backend = &fakeBackend{name: "fake", err: errors.New("permanent failure")}
+	cache.backends = []Backend{backend}
+	if err := cache.Update(context.Background()); err == nil {
+		t.Fatal("Expected error")
+	}
+	go cache.AutoRefresh(200*time.Millisecond, time.Second)
+	time.Sleep(100 * time.Millisecond)
+	if n := atomic.LoadInt32(&backend.calls); n != 1 {
+		t.Errorf("Expected 1 check, got %d", n)
+	}
+}
+
+func TestBackoffDelay(t *testing.T) {
+	for n := 0; n < 5; n++ {
+		max := time.Minute << uint(n)
+		for i := 0; i < 10; i++ {
+			if d := backoffDelay(time.Minute, n); d < max/2 || d > max {
+				t.Errorf("retry %d: expected between %v and %v, got %v", n, max/2, max, d)
+			}
+		}
+	}
+	if d := backoffDelay(time.Second, 1000); d <= 0 {
+		t.Errorf("Expected positive delay, got %v", d)
+	}
+}
+
+func TestParseBackendTimeouts(t *testing.T) {
+	timeouts, err := parseBackendTimeouts(time.Minute, []string{"pacman=5m", "flatpak=0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, expected := range map[string]time.Duration{"pacman": 5 * time.Minute, "flatpak": 0, "dnf": time.Minute} {
+		if actual := timeouts.get(name); actual != expected {
+			t.Errorf("%s: expected %v, got %v", name, expected, actual)
+		}
+	}
+	for _, s := range []string{"pacman", "pacman=5", "unknown=5m"} {
+		if _, err := parseBackendTimeouts(time.Minute, []string{s}); err == nil {
+			t.Errorf("%s: expected error", s)
+		}
+	}
+}
+
+func TestUpdateMultipleBackends(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+	cache = NewInternalCache()
+	native := &fakeBackend{
+		name: "native",
+		updates: api.UpdatesList{
+			{
+				Pkg:    "mesa",
+				OldVer: "20.2.3-1",
+				NewVer: "20.2.4-1",
+			},
+		},
+	}
+	extra := &fakeBackend{
+		name: "extra",
+		updates: api.UpdatesList{
+			{
+				Pkg:    "org.gimp.GIMP",
+				NewVer: "2.10.22",
+			},
+			{
+				Pkg:    "mesa",
+				NewVer: "20.2.4",
+			},
+		},
+	}
+	cache.backends = []Backend{native, extra}
+	if err := cache.Update(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	expected := api.UpdatesList{
+		{Pkg: "mesa", NewVer: "20.2.4", Backend: "extra"},
+		{Pkg: "mesa", OldVer: "20.2.3-1", NewVer: "20.2.4-1", Backend: "native"},
+		{Pkg: "org.gimp.GIMP", NewVer: "2.10.22", Backend: "extra"},
+	}
+	if len(cache.f.Updates) != len(expected) {
+		t.Fatalf("Expected %d updates, got %d: %v", len(expected), len(cache.f.Updates), cache.f.Updates)
+	}
+	for i, e := range expected {
+		if !e.Equals(&cache.f.Updates[i]) {
+			t.Errorf("Expected %v, got %v", e, cache.f.Updates[i])
+		}
+	}
+	if len(cache.f.Errors) != 0 {
+		t.Errorf("Expected no errors, got %v", cache.f.Errors)
+	}
+	// One backend fails, its previous results are kept
+	extra.updates = nil
+	extra.err = errors.New("remote unreachable")
+	native.updates = api.UpdatesList{}
+	if err := cache.Update(context.Background()); err == nil {
+		t.Error("Expected error on partial failure")
+	}
+	if len(cache.f.Updates) != 2 {
+		t.Fatalf("Expected 2 updates, got %d: %v", len(cache.f.Updates), cache.f.Updates)
+	}
+	for _, u := range cache.f.Updates {
+		if u.Backend != "extra" {
+			t.Errorf("Expected only updates from extra, got %v", u)
+		}
+	}
+	if msg := cache.f.Errors["extra"]; msg != "remote unreachable" {
+		t.Errorf("Expected error for extra, got %v", cache.f.Errors)
+	}
+	// Recovered
+	extra.updates = api.UpdatesList{}
+	extra.err = nil
+	if err := cache.Update(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(cache.f.Updates) != 0 {
+		t.Errorf("Expected 0 updates, got %d: %v", len(cache.f.Updates), cache.f.Updates)
+	}
+	if len(cache.f.Errors) != 0 {
+		t.Errorf("Expected no errors, got %v", cache.f.Errors)
+	}
+}
+
+func TestDetectBackends(t *testing.T) {
+	for distro, name := range map[string]string{
+		"fedora":              "dnf",
+		"centos":              "dnf",
+		"rhel":                "dnf",
+		"ol":                  "dnf",
+		"arch":                "pacman",
+		"manjaro":             "pacman",
+		"debian":              "apt",
+		"ubuntu":              "apt",
+		"raspbian":            "apt",
+		"pop":                 "apt",
+		"linuxmint":           "apt",
+		"opensuse-tumbleweed": "zypper",
+		"opensuse-leap":       "zypper",
+		"sles":                "zypper",
+		"alpine":              "apk",
+		"void":                "xbps",
+		"gentoo":              "emerge",
+		"nixos":               "nix",
+	} {
+		backends, err := detectBackends(context.Background(), distro, []string{name})
+		if err != nil {
+			t.Errorf("%s: %v", distro, err)
+			continue
+		}
+		if len(backends) != 1 || backends[0].Name() != name {
+			t.Errorf("%s: expected backend %s, got %v", distro, name, backends)
+		}
+	}
+	if _, err := detectBackends(context.Background(), "fedora", []string{"pacman"}); err == nil {
+		t.Error("Expected error when no enabled backend is detected")
+	}
+	if _, err := detectBackends(context.Background(), "fedora", []string{"nope"}); err == nil {
+		t.Error("Expected error for unknown backend")
+	}
+	if _, err := detectBackends(context.Background(), "plan9", nil); err == nil {
+		t.Error("Expected error for unsupported distro")
+	}
+}
+
+func TestDetectOptionalBackends(t *testing.T) {
+	defer func(orig []Backend) { registeredBackends = orig }(registeredBackends)
+	registeredBackends = []Backend{
+		&fakeBackend{name: "native"},
+		&fakeBackend{name: "extra", optional: true},
+	}
+	backends, err := detectBackends(context.Background(), "fake", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backends) != 1 || backends[0].Name() != "native" {
+		t.Errorf("Expected only native backend, got %v", backends)
+	}
+	backends, err = detectBackends(context.Background(), "fake", []string{"native", "extra"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backends) != 2 {
+		t.Errorf("Expected both backends when enabled, got %v", backends)
+	}
+}
+
 func TestWatchLogs(t *testing.T) {
 	log.SetLevel(log.DebugLevel)
 	cache = NewInternalCache()
-	cache.f.Checked = "2020-05-29T23:00:00+02:00"
-	cache.logFp = "/tmp/test_watch.log"
-	cache.logFunc = checkPacmanLogs
+	cache.backends = []Backend{&pacmanBackend{logFp: "/tmp/test_watch.log"}}
 	write := func(content string) error {
-		err := ioutil.WriteFile(cache.logFp, []byte(content), 0644)
+		err := ioutil.WriteFile(cache.backends[0].LogPaths()[0], []byte(content), 0644)
 		if err != nil {
 			return err
 		}
@@ -37,8 +695,15 @@ func TestWatchLogs(t *testing.T) {
 			NewVer: "0.60.3-1",
 		},
 	}
-	cache.f.Updates = allUpdates
-	go cache.WatchLogs(time.Second)
+	setTestFile(cache, allUpdates, "2020-05-29T23:00:00+02:00")
+	lifetime, stop := context.WithCancel(context.Background())
+	defer stop()
+	cache.ctx = lifetime
+	done := make(chan struct{})
+	go func() {
+		cache.WatchLogs(time.Second)
+		close(done)
+	}()
 	file := `
 [2020-05-29T23:47:18+0200] [ALPM] upgraded shellcheck (0.7.1-32 -> 0.7.1-33)
 [2020-05-29T23:47:18+0200] [ALPM] upgraded vte-common (0.60.2-2 -> 0.60.3-1)
@@ -49,11 +714,11 @@ func TestWatchLogs(t *testing.T) {
 	}
 	// Wait for ticker
 	time.Sleep(2 * time.Second)
-	if len(cache.f.Updates) > 0 {
-		t.Errorf("Expected 0 updates, got %d: %v", len(cache.f.Updates), cache.f.Updates)
+	if f := cache.Snapshot(); len(f.Updates) > 0 {
+		t.Errorf("Expected 0 updates, got %d: %v", len(f.Updates), f.Updates)
 	}
 	// Only upgrade 1 of them
-	cache.f.Updates = allUpdates
+	setTestFile(cache, allUpdates, "2020-05-29T23:00:00+02:00")
 	file = `
 [2020-05-29T23:47:18+0200] [ALPM] upgraded shellcheck (0.7.1-32 -> 0.7.1-33)
 `
@@ -61,7 +726,164 @@ func TestWatchLogs(t *testing.T) {
 		t.Error(err)
 	}
 	time.Sleep(2 * time.Second)
-	if len(cache.f.Updates) != 2 {
-		t.Errorf("Expected 2 updates, got %d: %v", len(cache.f.Updates), cache.f.Updates)
+	if f := cache.Snapshot(); len(f.Updates) != 2 {
+		t.Errorf("Expected 2 updates, got %d: %v", len(f.Updates), f.Updates)
+	}
+	// Stops with the program
+	stop()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("Expected WatchLogs to return once cancelled")
+	}
+}
+
+// TestCacheConcurrent hammers the cache from all its readers and writers at once, run with -race
+func TestCacheConcurrent(t *testing.T) {
+	log.SetLevel(log.WarnLevel)
+	defer log.SetLevel(log.DebugLevel)
+	tmp, err := ioutil.TempDir("", "test_concurrent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	logFp := filepath.Join(tmp, "fake.log")
+	writeTestFile(t, logFp, "")
+	cache = NewInternalCache()
+	cache.fp = filepath.Join(tmp, "cache.json")
+	cache.restart = nil
+	backend := &fakeBackend{
+		name:  "fake",
+		logFp: logFp,
+		updates: api.UpdatesList{
+			{Pkg: "zlib", OldVer: "1.2.11-3", NewVer: "1.2.11-4", CVEs: []string{"CVE-2018-25032"}},
+			{Pkg: "bash", OldVer: "5.0.018-1", NewVer: "5.0.018-2"},
+		},
+	}
+	cache.backends = []Backend{backend}
+	if err := cache.Update(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/ws" {
+			HandleWS(w, r)
+			return
+		}
+		HandleAPI(w, r)
+	}))
+	defer ts.Close()
+	// Consistency is checked by readers, every refresh returns both updates
+	checkFile := func(f api.File) error {
+		if len(f.Updates) != 0 && len(f.Updates) != 2 {
+			return fmt.Errorf("expected 0 or 2 updates, got %d: %v", len(f.Updates), f.Updates)
+		}
+		if len(f.Updates) != 0 && f.Checked == "" {
+			return fmt.Errorf("expected checked timestamp with %d updates", len(f.Updates))
+		}
+		return nil
+	}
+	const numIter = 20
+	errCh := make(chan error, 100)
+	var wg sync.WaitGroup
+	run := func(fn func(i int) error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < numIter; i++ {
+				if err := fn(i); err != nil {
+					errCh <- err
+					return
+				}
+			}
+		}()
+	}
+	// Websocket clients read until the connection is closed, broadcasts may be coalesced
+	var wsWg sync.WaitGroup
+	wsConns := make([]*websocket.Conn, 3)
+	wsRecv := make([]int, len(wsConns))
+	for n := range wsConns {
+		c, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/ws", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wsConns[n] = c
+		wsWg.Add(1)
+		go func(n int) {
+			defer wsWg.Done()
+			for {
+				var f api.File
+				if err := wsConns[n].ReadJSON(&f); err != nil {
+					return
+				}
+				wsRecv[n]++
+				if err := checkFile(f); err != nil {
+					errCh <- err
+					return
+				}
+			}
+		}(n)
+	}
+	run(func(i int) error { return cache.Update(context.Background()) })
+	run(func(i int) error { return cache.RefreshFromLogs() })
+	run(func(i int) error {
+		writeTestFile(t, logFp, fmt.Sprintf("line %d\n", i))
+		cache.parseChangedLogs(make(map[string]time.Time))
+		return nil
+	})
+	run(func(i int) error {
+		f, err := cache.GetFile()
+		if err != nil {
+			return err
+		}
+		return checkFile(f)
+	})
+	run(func(i int) error {
+		// Modifying a snapshot must not affect the cache
+		f := cache.Snapshot()
+		for j := range f.Updates {
+			f.Updates[j].Pkg = "modified"
+			f.Updates[j].CVEs = append(f.Updates[j].CVEs[:0], "modified")
+		}
+		return checkFile(f)
+	})
+	run(func(i int) error {
+		query := "/api?updates"
+		if i%2 == 0 {
+			query += "&refresh"
+		}
+		resp, err := http.Get(ts.URL + query)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		var data api.Response
+		if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+			return err
+		}
+		if data.Data == nil {
+			return fmt.Errorf("no data in response: %s", data.Error)
+		}
+		return checkFile(*data.Data)
+	})
+	wg.Wait()
+	// Let the websocket writers catch up with the last broadcast
+	time.Sleep(100 * time.Millisecond)
+	for _, c := range wsConns {
+		c.Close()
+	}
+	wsWg.Wait()
+	close(errCh)
+	for err := range errCh {
+		t.Error(err)
+	}
+	for n, count := range wsRecv {
+		if count == 0 {
+			t.Errorf("websocket client %d received nothing", n)
+		}
+	}
+	for _, u := range cache.Snapshot().Updates {
+		if u.Pkg == "modified" || containsString(u.CVEs, "modified") {
+			t.Errorf("snapshot modification leaked into cache: %v", u)
+		}
 	}
 }
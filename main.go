@@ -1,11 +1,16 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"path"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/alexflint/go-arg"
@@ -19,14 +24,20 @@ const (
 	packageName   string = "go-check-updates"
 	defaultWait   string = "12h"
 	defaultNotify string = "1h"
+	// defaultTimeout is the maximum duration of a backend check
+	defaultTimeout string = "10m"
 )
 
 var aur = helper{}
 var cache = NewInternalCache()
 var args struct {
+	ArchSecurity   string        `arg:"--arch.security-file,env:ARCH_SECURITY_FILE" help:"Security tracker JSON to use instead of arch-audit (Arch Linux)"`
 	AurHelper      string        `arg:"--aur" help:"Override AUR helper (Arch Linux)"`
+	Backends       []string      `arg:"--backends,env:BACKENDS" help:"Only enable these backends, default is all detected"`
+	BackendTimeout []string      `arg:"--timeout.backend,env:BACKEND_TIMEOUT" help:"Per backend check timeouts as name=duration, e.g. pacman=5m"`
 	CacheFile      string        `arg:"--cache.file,env:CACHE_FILE" help:"Path to update cache file"`
 	CacheInterval  time.Duration `arg:"--cache.interval,env:CACHE_INTERVAL" help:"Time interval between cache updates"`
+	CacheStale     time.Duration `arg:"--cache.stale,env:CACHE_STALE" help:"Mark updates as stale if the last successful check is older than this, default is twice the cache interval"`
 	Daemon         bool          `arg:"-d,--daemon" help:"Run as a daemon"`
 	Debug          bool          `arg:"--debug,env:DEBUG" help:"Set console log output to DEBUG"`
 	ListenAddress  string        `arg:"--web.listen-address,env:LISTEN_ADDRESS" help:"Web server listen address" default:":8100"`
@@ -37,11 +48,14 @@ var args struct {
 	NoRefresh      bool          `arg:"--no-refresh,env:NO_REFRESH" help:"Don't auto-refresh"`
 	NoSource       bool          `arg:"--no-source,env:NO_SOURCE" help:"Ignore source packages (RedHat)"`
 	Notify         bool          `arg:"--notify.enable,env:NOTIFY_ENABLE" help:"Enable notifications, webhook URL is required"`
+	NotifyChange   []string      `arg:"--notify.change,env:NOTIFY_CHANGE" help:"Only send updates with these change levels (major, minor, patch or pkgrel) in notifications"`
 	NotifyDelta    bool          `arg:"--notify.delta,env:NOTIFY_DELTA" help:"Only send differences in notifications"`
 	NotifyFormat   string        `arg:"--notify.format,env:NOTIFY_FORMAT" help:"Time format for embed footer" default:"2006/01/02 15:04"`
 	NotifyInterval time.Duration `arg:"--notify.interval,env:NOTIFY_INTERVAL" help:"Minimum time between notifications"`
 	Quiet          bool          `arg:"-q,--quiet" help:"Don't log to console"`
+	RetryDelay     time.Duration `arg:"--retry.delay,env:RETRY_DELAY" help:"Delay before retrying a failed refresh, doubled after every failure, 0 to disable" default:"1m"`
 	Systemd        bool          `arg:"--systemd" help:"Run HTTP server using systemd socket activation"`
+	Timeout        time.Duration `arg:"--timeout,env:TIMEOUT" help:"Maximum time a backend check may take, 0 to disable"`
 	Watch          bool          `arg:"-w,--watch.enable,env:WATCH_ENABLE" help:"Watch for package manager log file updates"`
 	WatchInterval  time.Duration `arg:"--watch.interval,env:WATCH_INTERVAL" help:"Time interval between package manager log file checks" default:"10s"`
 	WebhookURL     string        `arg:"--webhook-url,env:WEBHOOK_URL" help:"Discord Webhook URL"`
@@ -108,37 +122,25 @@ func setupDistro() {
 	if err != nil {
 		log.Fatalln(err)
 	}
-	switch distro {
-	case "fedora", "centos", "rhel", "ol":
-		cache.updateFunc = UpdateDnf
-		cache.logFp = "/var/log/dnf.rpm.log"
-		cache.logFunc = checkDnfLogs
-	case "arch", "manjaro":
-		cache.logFp = "/var/log/pacman.log"
-		cache.logFunc = checkPacmanLogs
-		cache.updateFunc = UpdateArch
-		for _, h := range supportedHelpers {
-			if !checkCmd(h.name) {
-				continue
-			}
-			if args.AurHelper != "" && h.name != args.AurHelper {
-				log.Infof("%s is available but %s was requested", h.name, args.AurHelper)
-				continue
-			}
-			aur = h
-			break
-		}
-		if aur.name == "" {
-			log.Warn("no supported AUR helper found")
-		} else {
-			log.Infof("AUR helper: %s", aur.name)
-		}
-	default:
-		log.Fatalf("unsupported distro %s", distro)
+	cache.backends, err = detectBackends(cache.ctx, distro, args.Backends)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	for _, b := range cache.backends {
+		log.Infof("backend: %s (%s)", b.Name(), b.Capabilities())
+	}
+	cache.restart.Detect()
+	cache.timeouts, err = parseBackendTimeouts(args.Timeout, args.BackendTimeout)
+	if err != nil {
+		log.Fatalln(err)
 	}
 }
 
 func setupCache() {
+	cache.staleAfter = args.CacheStale
+	if cache.staleAfter == 0 {
+		cache.staleAfter = 2 * args.CacheInterval
+	}
 	if args.NoCache {
 		cache.fp = ""
 		log.Info("cache file disabled")
@@ -151,6 +153,10 @@ func setupCache() {
 	if checkFileRead(cache.fp) && !checkFileWrite(cache.fp) {
 		log.Fatal("cache file is not writable")
 	}
+	// The file is replaced with a temporary one from the same directory
+	if !checkFileWrite(path.Dir(cache.fp)) {
+		log.Fatal("cache file directory is not writable")
+	}
 	log.Infof("cache file: %s", cache.fp)
 }
 
@@ -159,19 +165,8 @@ func setupAutoRefresh() {
 		log.Info("auto-refresh disabled")
 		return
 	}
-	log.Infof("auto-refresh every %v", args.CacheInterval)
-	go func() {
-		ticker := time.NewTicker(args.CacheInterval)
-		for {
-			select {
-			case <-ticker.C:
-				log.Debug("auto-refresh ticker")
-				if err := cache.Update(); err != nil {
-					log.Error(err)
-				}
-			}
-		}
-	}()
+	log.Infof("auto-refresh every %v, retry after %v", args.CacheInterval, args.RetryDelay)
+	go cache.AutoRefresh(args.CacheInterval, args.RetryDelay)
 }
 
 func setupWatch() {
@@ -179,10 +174,10 @@ func setupWatch() {
 		log.Info("watch disabled")
 		return
 	}
-	if cache.logFp == "" {
+	if logPaths := cache.LogPaths(); len(logPaths) == 0 {
 		log.Error("cannot watch, unsupported package manager")
 	} else {
-		log.Infof("watching %s, checking every %s", cache.logFp, args.WatchInterval)
+		log.Infof("watching %s, checking every %s", strings.Join(logPaths, ", "), args.WatchInterval)
 		go cache.WatchLogs(args.WatchInterval)
 	}
 }
@@ -195,50 +190,72 @@ func setupNotify() {
 		log.Error("notifications disabled, missing Discord webhook URL")
 		return
 	}
+	for _, l := range args.NotifyChange {
+		if !containsString(changeLevels, l) {
+			log.Errorf("notifications disabled, unknown change level '%s'", l)
+			return
+		}
+	}
 	log.Infof("notify interval %v", args.NotifyInterval)
 	go func() {
 		sub := cache.ws.Subscribe()
 		defer sub.Unsubscribe()
-		prevNotifyUpdates = make(api.UpdatesList, 0)
-		var prevNotify time.Time
+		prev := notifyState{updates: make(api.UpdatesList, 0)}
 		for {
 			select {
 			case <-sub.ch:
 				log.Debug("notify received broadcast")
-				curUpdates := len(cache.f.Updates)
-				prevUpdates := len(prevNotifyUpdates)
-				if curUpdates == prevUpdates || time.Since(prevNotify) < args.NotifyInterval {
+				f := cache.Snapshot()
+				curUpdates := len(f.Updates)
+				prevUpdates := len(prev.updates)
+				rebootChanged := f.RebootRequired != prev.reboot
+				if (curUpdates == prevUpdates && !rebootChanged) || time.Since(prev.sent) < args.NotifyInterval {
 					continue
 				}
 				log.Debugf("[notify] update count changed from %d to %d", prevUpdates, curUpdates)
-				if err := sendUpdatesNotification(); err != nil {
+				if err := sendUpdatesNotification(&f, &prev); err != nil {
 					log.Warnf("failed to send notification: %v", err)
 				}
-				prevNotifyUpdates = cache.f.Updates.Copy()
-				prevNotify = time.Now()
+				prev.updates = f.Updates
+				prev.reboot = f.RebootRequired
+				prev.sent = time.Now()
 			}
 		}
 	}()
 }
 
 func runDaemon(listener net.Listener) {
-	setupAutoRefresh()
 	setupWatch()
 	setupNotify()
 	http.HandleFunc("/api", HandleAPI)
 	http.HandleFunc("/ws", HandleWS)
 	if cache.NeedsUpdate(args.CacheInterval) {
-		if err := cache.Update(); err != nil {
+		if err := cache.Update(cache.ctx); err != nil {
 			log.Errorf("refresh failed: %v", err)
 		}
-		log.Infof("found %d updates", len(cache.f.Updates))
+		f := cache.Snapshot()
+		log.Infof("found %d updates", len(f.Updates))
 	}
+	// Started after the first refresh so that it is retried if it failed
+	setupAutoRefresh()
+	srv := &http.Server{}
+	go func() {
+		<-cache.ctx.Done()
+		log.Info("shutting down")
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Warnf("HTTP shutdown: %v", err)
+		}
+	}()
 	log.Infof("listening on %s", listener.Addr().String())
-	err := http.Serve(listener, nil)
+	err := srv.Serve(listener)
 	if err != http.ErrServerClosed {
 		log.Errorf("HTTP serve error: %v", err)
 		os.Exit(2)
 	}
+	// Let the cancelled refresh kill its commands
+	cache.Wait()
 	os.Exit(0)
 }
 
@@ -247,20 +264,38 @@ func runForeground() {
 		log.Info("no update required")
 		return
 	}
-	if err := cache.Update(); err != nil {
+	err := cache.Update(cache.ctx)
+	cache.Wait()
+	f := cache.Snapshot()
+	if err != nil {
 		log.Errorf("refresh failed: %v", err)
-		return
+		if f.IsEmpty() {
+			return
+		}
 	}
 	// Print to console
-	fmt.Print(cache.f.String())
+	fmt.Print(f.String())
 }
 
 func main() {
 	args.CacheFile, _ = getCachePath()
 	args.CacheInterval, _ = time.ParseDuration(defaultWait)
 	args.NotifyInterval, _ = time.ParseDuration(defaultNotify)
+	args.Timeout, _ = time.ParseDuration(defaultTimeout)
 	arg.MustParse(&args)
 
+	// Cancel refreshes in progress on shutdown
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		log.Infof("received %v", <-sig)
+		cancel()
+		signal.Stop(sig)
+	}()
+	cache.ctx = ctx
+
 	file := setupLogging()
 	if file != nil {
 		defer file.Close()
@@ -0,0 +1,212 @@
+package main
+
+/*
+$ nix-channel --list
+nixos https://nixos.org/channels/nixos-20.09
+
+$ nixos-version --revision
+ae1b121d9a68518dbf46124397e34e465d3cdf6c
+
+$ curl -L https://nixos.org/channels/nixos-20.09/git-revision
+e065200fc90175a8f6e50e76ef10a48786126e1c
+
+$ nix store diff-closures /run/current-system /nix/store/...-nixos-system-host-20.09.2449.e065200fc90
+firefox: 84.0 → 84.0.2, +1024.3 KiB
+glibc: 2.31-74 → 2.32-10, -12.5 KiB
+nixos-system-host: 20.09.2386.ae1b121d9a6 → 20.09.2449.e065200fc90
+zstd: ∅ → 1.4.5, +1000.2 KiB
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cosandr/go-check-updates/api"
+)
+
+const nixHTTPTimeout = 30 * time.Second
+
+// nixExperimental enables the new CLI on Nix versions where it is experimental
+var nixExperimental = []string{"--extra-experimental-features", "nix-command flakes"}
+
+// Group 1: name
+// Group 2: old version(s), ∅ if not present
+// Group 3: new version(s), ∅ if not present
+var reNixDiffClosures = regexp.MustCompile(`(?m)^(\S+): (.+?) → (.+?)(?:, [+-][\d.]+ [KMGT]?i?B)?\s*$`)
+
+// nixBackend builds the system configuration from the latest channel or flake inputs and
+// compares its closure to the current system, nothing is switched to or written to the lock file
+type nixBackend struct {
+	channel       string
+	flakeDir      string
+	currentSystem string
+}
+
+func init() {
+	registerBackend(&nixBackend{
+		channel:       "nixos",
+		flakeDir:      "/etc/nixos",
+		currentSystem: "/run/current-system",
+	})
+}
+
+func (b *nixBackend) Name() string { return "nix" }
+
+func (b *nixBackend) Detect(ctx context.Context, distro string) bool { return distro == "nixos" }
+
+func (b *nixBackend) Check(ctx context.Context) (api.UpdatesList, error) {
+	var newSystem, repo string
+	var err error
+	if checkFileExists(path.Join(b.flakeDir, "flake.nix")) {
+		repo = "flake"
+		newSystem, err = b.buildFlake(ctx)
+	} else {
+		repo = b.channel
+		newSystem, err = b.buildChannel(ctx)
+	}
+	if err != nil || newSystem == "" {
+		return api.UpdatesList{}, err
+	}
+	current, err := os.Readlink(b.currentSystem)
+	if err != nil {
+		return api.UpdatesList{}, err
+	}
+	if current == newSystem {
+		return make(api.UpdatesList, 0), nil
+	}
+	cmdArgs := append(nixExperimental, "store", "diff-closures", current, newSystem)
+	raw, err := runCmd(ctx, "nix", cmdArgs...)
+	if err != nil {
+		return api.UpdatesList{}, err
+	}
+	return parseNixDiffClosures(raw, repo), nil
+}
+
+func (b *nixBackend) LogPaths() []string { return nil }
+
+func (b *nixBackend) ParseLog(ctx context.Context, fp string, f *api.File) error {
+	return fmt.Errorf("nix has no log file")
+}
+
+func (b *nixBackend) Capabilities() Capability { return CapOldVersion | CapRepo }
+
+// buildChannel builds the system from the latest channel release
+//
+// An empty path is returned if the current system is already at the latest revision
+func (b *nixBackend) buildChannel(ctx context.Context) (string, error) {
+	raw, err := runCmd(ctx, "nix-channel", "--list")
+	if err != nil {
+		return "", err
+	}
+	url, ok := parseNixChannels(raw)[b.channel]
+	if !ok {
+		return "", fmt.Errorf("channel %s not found", b.channel)
+	}
+	if latest, err := nixLatestRevision(ctx, url); err != nil {
+		log.Debugf("nix: cannot get latest revision of %s: %v", url, err)
+	} else if current, err := runCmd(ctx, "nixos-version", "--revision"); err != nil {
+		log.Debugf("nix: cannot get current revision: %v", err)
+	} else if strings.TrimSpace(current) == latest {
+		log.Debugf("nix: %s is at latest revision %s", b.channel, latest)
+		return "", nil
+	}
+	raw, err = runCmd(ctx, "nix-build", "<nixpkgs/nixos>", "-A", "system", "--no-out-link",
+		"-I", "nixpkgs="+strings.TrimSuffix(url, "/")+"/nixexprs.tar.xz")
+	if err != nil {
+		return "", fmt.Errorf("nix-build failed: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(raw), "\n")
+	return lines[len(lines)-1], nil
+}
+
+// buildFlake builds this host's configuration with all flake inputs updated
+func (b *nixBackend) buildFlake(ctx context.Context) (string, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", err
+	}
+	attr := fmt.Sprintf("%s#nixosConfigurations.%s.config.system.build.toplevel", b.flakeDir, hostname)
+	cmdArgs := append(nixExperimental, "build", attr, "--no-link", "--json",
+		"--recreate-lock-file", "--no-write-lock-file")
+	raw, err := runCmd(ctx, "nix", cmdArgs...)
+	if err != nil {
+		return "", fmt.Errorf("nix build failed: %v", err)
+	}
+	return parseNixBuildJSON(raw)
+}
+
+// nixLatestRevision returns the nixpkgs revision of the latest release of a channel
+func nixLatestRevision(ctx context.Context, url string) (string, error) {
+	client := http.Client{Timeout: nixHTTPTimeout}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(url, "/")+"/git-revision", nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(body)), nil
+}
+
+// parseNixChannels returns a map of channel name -> URL
+func parseNixChannels(out string) map[string]string {
+	ret := make(map[string]string)
+	for _, line := range strings.Split(out, "\n") {
+		cols := strings.Fields(line)
+		if len(cols) != 2 {
+			continue
+		}
+		ret[cols[0]] = cols[1]
+	}
+	return ret
+}
+
+// parseNixBuildJSON returns the output path from nix build --json
+func parseNixBuildJSON(out string) (string, error) {
+	var results []struct {
+		Outputs map[string]string `json:"outputs"`
+	}
+	if err := json.Unmarshal([]byte(out), &results); err != nil {
+		return "", fmt.Errorf("cannot unmarshal nix build output: %v", err)
+	}
+	if len(results) == 0 || results[0].Outputs["out"] == "" {
+		return "", fmt.Errorf("nix build returned no output path")
+	}
+	return results[0].Outputs["out"], nil
+}
+
+// parseNixDiffClosures returns packages with a changed version, added and removed packages are ignored
+func parseNixDiffClosures(out string, repo string) api.UpdatesList {
+	updates := make(api.UpdatesList, 0)
+	for _, m := range reNixDiffClosures.FindAllStringSubmatch(out, -1) {
+		if m[2] == "∅" || m[3] == "∅" {
+			continue
+		}
+		updates = append(updates, api.Update{
+			Pkg:    m[1],
+			OldVer: m[2],
+			NewVer: m[3],
+			Repo:   repo,
+		})
+	}
+	return updates
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cosandr/go-check-updates/api"
+)
+
+func TestNixParseDiffClosures(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+	out := `firefox: 84.0 → 84.0.2, +1024.3 KiB
+glibc: 2.31-74 → 2.32-10, -12.5 KiB
+libpng: 1.6.37: +0.1 KiB
+linux: 5.4.84 → 5.4.85
+nixos-system-host: 20.09.2386.ae1b121d9a6 → 20.09.2449.e065200fc90
+python3: 3.7.9, 3.8.6 → 3.8.7, -40280.4 KiB
+zstd: ∅ → 1.4.5, +1000.2 KiB
+zlib: 1.2.11 → ∅, -120.0 KiB
+`
+	actual := parseNixDiffClosures(out, "nixos")
+	expected := api.UpdatesList{
+		{
+			Pkg:    "firefox",
+			OldVer: "84.0",
+			NewVer: "84.0.2",
+			Repo:   "nixos",
+		},
+		{
+			Pkg:    "glibc",
+			OldVer: "2.31-74",
+			NewVer: "2.32-10",
+			Repo:   "nixos",
+		},
+		{
+			Pkg:    "linux",
+			OldVer: "5.4.84",
+			NewVer: "5.4.85",
+			Repo:   "nixos",
+		},
+		{
+			Pkg:    "nixos-system-host",
+			OldVer: "20.09.2386.ae1b121d9a6",
+			NewVer: "20.09.2449.e065200fc90",
+			Repo:   "nixos",
+		},
+		{
+			Pkg:    "python3",
+			OldVer: "3.7.9, 3.8.6",
+			NewVer: "3.8.7",
+			Repo:   "nixos",
+		},
+	}
+	if len(actual) != len(expected) {
+		t.Fatalf("expected %d updates, got %d: %v", len(expected), len(actual), actual)
+	}
+	for i, a := range actual {
+		if e := expected[i]; !a.Equals(&e) {
+			t.Errorf("expected %v, got %v", e, a)
+		}
+	}
+}
+
+func TestNixChannels(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+	channels := parseNixChannels("nixos https://nixos.org/channels/nixos-20.09\nhome-manager https://github.com/nix-community/home-manager/archive/release-20.09.tar.gz\n")
+	if url := channels["nixos"]; url != "https://nixos.org/channels/nixos-20.09" {
+		t.Errorf("expected nixos-20.09 channel, got '%s'", url)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/channels/nixos-20.09/git-revision" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintln(w, "e065200fc90175a8f6e50e76ef10a48786126e1c")
+	}))
+	defer ts.Close()
+	rev, err := nixLatestRevision(context.Background(), ts.URL+"/channels/nixos-20.09/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rev != "e065200fc90175a8f6e50e76ef10a48786126e1c" {
+		t.Errorf("unexpected revision '%s'", rev)
+	}
+	if _, err := nixLatestRevision(context.Background(), ts.URL+"/channels/nixos-unstable"); err == nil {
+		t.Errorf("expected error for missing channel")
+	}
+}
+
+func TestNixParseBuildJSON(t *testing.T) {
+	out := `[{"drvPath":"/nix/store/0c4x9kq7r0b0v1l1a1bv8p9fgvxz1ir0-nixos-system-host-21.03.20201223.e065200.drv","outputs":{"out":"/nix/store/3jkd2ybd4rm2p8f0ms4ky2b2dmr6p1x9-nixos-system-host-21.03.20201223.e065200"}}]`
+	fp, err := parseNixBuildJSON(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fp != "/nix/store/3jkd2ybd4rm2p8f0ms4ky2b2dmr6p1x9-nixos-system-host-21.03.20201223.e065200" {
+		t.Errorf("unexpected output path '%s'", fp)
+	}
+	if _, err := parseNixBuildJSON("[]"); err == nil {
+		t.Errorf("expected error for empty output")
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+/*
+$ npm outdated -g --json
+{
+  "npm": {
+    "current": "6.14.8",
+    "wanted": "6.14.10",
+    "latest": "6.14.10",
+    "location": "/usr/lib/node_modules/npm"
+  }
+}
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/cosandr/go-check-updates/api"
+)
+
+// npmOutdated is a single package in npm outdated --json
+type npmOutdated struct {
+	Current string `json:"current"`
+	Wanted  string `json:"wanted"`
+	Latest  string `json:"latest"`
+}
+
+// npmBackend checks globally installed npm packages, must be enabled explicitly
+type npmBackend struct{}
+
+func init() {
+	registerBackend(&npmBackend{})
+}
+
+func (b *npmBackend) Name() string { return "npm" }
+
+func (b *npmBackend) Detect(ctx context.Context, distro string) bool { return checkCmd("npm") }
+
+func (b *npmBackend) Check(ctx context.Context) (api.UpdatesList, error) {
+	raw, err := runCmd(ctx, "npm", "outdated", "--global", "--json")
+	if err != nil {
+		// 1 means there are outdated packages
+		exitError, ok := err.(*exec.ExitError)
+		if !ok || exitError.ExitCode() != 1 || strings.TrimSpace(raw) == "" {
+			return api.UpdatesList{}, err
+		}
+	}
+	return parseNpmOutdated(raw)
+}
+
+func (b *npmBackend) LogPaths() []string { return nil }
+
+func (b *npmBackend) ParseLog(ctx context.Context, fp string, f *api.File) error {
+	return fmt.Errorf("npm has no log file")
+}
+
+func (b *npmBackend) Capabilities() Capability { return CapOldVersion | CapRepo }
+
+func (b *npmBackend) Optional() bool { return true }
+
+// parseNpmOutdated returns installed packages with a newer latest version, empty output means nothing is outdated
+func parseNpmOutdated(out string) (api.UpdatesList, error) {
+	updates := make(api.UpdatesList, 0)
+	if strings.TrimSpace(out) == "" {
+		return updates, nil
+	}
+	var outdated map[string]npmOutdated
+	if err := json.Unmarshal([]byte(out), &outdated); err != nil {
+		return updates, fmt.Errorf("cannot unmarshal npm output: %v", err)
+	}
+	names := make([]string, 0, len(outdated))
+	for name := range outdated {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		p := outdated[name]
+		if p.Current == "" || p.Latest == "" || vercmpSemver(p.Latest, p.Current) <= 0 {
+			continue
+		}
+		updates = append(updates, api.Update{
+			Pkg:    name,
+			OldVer: p.Current,
+			NewVer: p.Latest,
+			Repo:   "npm",
+			Scope:  "user",
+		})
+	}
+	return updates, nil
+}
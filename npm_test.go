@@ -0,0 +1,71 @@
+package main
+
+import (
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cosandr/go-check-updates/api"
+)
+
+func TestNpmParseOutdated(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+	out := `{
+  "npm": {
+    "current": "6.14.8",
+    "wanted": "6.14.10",
+    "latest": "6.14.10",
+    "location": "/usr/lib/node_modules/npm"
+  },
+  "eslint": {
+    "current": "7.15.0",
+    "wanted": "7.15.0",
+    "latest": "7.16.0",
+    "location": "/usr/lib/node_modules/eslint"
+  },
+  "typescript": {
+    "current": "4.2.0-beta",
+    "wanted": "4.2.0-beta",
+    "latest": "4.1.3",
+    "location": "/usr/lib/node_modules/typescript"
+  },
+  "missing": {
+    "wanted": "1.0.0",
+    "latest": "1.0.0",
+    "location": ""
+  }
+}`
+	actual, err := parseNpmOutdated(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := api.UpdatesList{
+		{
+			Pkg:    "eslint",
+			OldVer: "7.15.0",
+			NewVer: "7.16.0",
+			Repo:   "npm",
+			Scope:  "user",
+		},
+		{
+			Pkg:    "npm",
+			OldVer: "6.14.8",
+			NewVer: "6.14.10",
+			Repo:   "npm",
+			Scope:  "user",
+		},
+	}
+	if len(actual) != len(expected) {
+		t.Fatalf("expected %d updates, got %d: %v", len(expected), len(actual), actual)
+	}
+	for i, a := range actual {
+		if e := expected[i]; !a.Equals(&e) {
+			t.Errorf("expected %v, got %v", e, a)
+		}
+	}
+	// Nothing outdated
+	actual, err = parseNpmOutdated("")
+	if err != nil || len(actual) != 0 {
+		t.Errorf("expected 0 updates, got %v: %v", actual, err)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+/*
+$ pipx list --json
+{
+  "pipx_spec_version": "0.1",
+  "venvs": {
+    "black": {
+      "metadata": {
+        "main_package": {
+          "package": "black",
+          "package_or_url": "black",
+          "package_version": "20.8b1"
+        }
+      }
+    }
+  }
+}
+
+$ curl https://pypi.org/pypi/black/json
+{"info":{"name":"black","version":"20.8b1",...},...}
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cosandr/go-check-updates/api"
+)
+
+// pipxList is the output of pipx list --json
+type pipxList struct {
+	Venvs map[string]struct {
+		Metadata struct {
+			MainPackage struct {
+				Package        string `json:"package"`
+				PackageOrURL   string `json:"package_or_url"`
+				PackageVersion string `json:"package_version"`
+			} `json:"main_package"`
+		} `json:"metadata"`
+	} `json:"venvs"`
+}
+
+// pypiResponse is the subset of the PyPI JSON API response used here
+type pypiResponse struct {
+	Info struct {
+		Version string `json:"version"`
+	} `json:"info"`
+}
+
+// pipxBackend checks applications installed with pipx against PyPI, must be enabled explicitly
+type pipxBackend struct {
+	registry string
+}
+
+func init() {
+	registerBackend(&pipxBackend{registry: "https://pypi.org/pypi"})
+}
+
+func (b *pipxBackend) Name() string { return "pipx" }
+
+func (b *pipxBackend) Detect(ctx context.Context, distro string) bool { return checkCmd("pipx") }
+
+func (b *pipxBackend) Check(ctx context.Context) (api.UpdatesList, error) {
+	raw, err := runCmd(ctx, "pipx", "list", "--json")
+	if err != nil {
+		return api.UpdatesList{}, err
+	}
+	installed, err := parsePipxList(raw)
+	if err != nil {
+		return api.UpdatesList{}, err
+	}
+	return b.checkRegistry(ctx, installed)
+}
+
+func (b *pipxBackend) LogPaths() []string { return nil }
+
+func (b *pipxBackend) ParseLog(ctx context.Context, fp string, f *api.File) error {
+	return fmt.Errorf("pipx has no log file")
+}
+
+func (b *pipxBackend) Capabilities() Capability { return CapOldVersion | CapRepo }
+
+func (b *pipxBackend) Optional() bool { return true }
+
+// checkRegistry looks up the latest version of every installed package
+//
+// Packages which cannot be looked up are skipped, the first error is returned
+func (b *pipxBackend) checkRegistry(ctx context.Context, installed map[string]string) (updates api.UpdatesList, err error) {
+	updates = make(api.UpdatesList, 0)
+	for _, name := range sortedKeys(installed) {
+		var resp pypiResponse
+		if e := httpGetJSON(ctx, b.registry+"/"+name+"/json", &resp); e != nil {
+			log.Debugf("pipx: cannot look up %s: %v", name, e)
+			if err == nil {
+				err = fmt.Errorf("cannot look up %s: %v", name, e)
+			}
+			continue
+		}
+		if resp.Info.Version == "" || vercmpPep440(resp.Info.Version, installed[name]) <= 0 {
+			continue
+		}
+		updates = append(updates, api.Update{
+			Pkg:    name,
+			OldVer: installed[name],
+			NewVer: resp.Info.Version,
+			Repo:   "pypi",
+			Scope:  "user",
+		})
+	}
+	return
+}
+
+// parsePipxList returns a map of package name -> version, packages installed from a URL or path are skipped
+func parsePipxList(out string) (map[string]string, error) {
+	var list pipxList
+	if err := json.Unmarshal([]byte(out), &list); err != nil {
+		return nil, fmt.Errorf("cannot unmarshal pipx output: %v", err)
+	}
+	ret := make(map[string]string)
+	for _, v := range list.Venvs {
+		p := v.Metadata.MainPackage
+		if strings.ContainsAny(p.PackageOrURL, "/:") {
+			continue
+		}
+		ret[p.Package] = p.PackageVersion
+	}
+	return ret, nil
+}
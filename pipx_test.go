@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cosandr/go-check-updates/api"
+)
+
+func TestPipxCheckRegistry(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+	out := `{
+  "pipx_spec_version": "0.1",
+  "venvs": {
+    "black": {
+      "metadata": {
+        "injected_packages": {},
+        "main_package": {
+          "app_paths": [],
+          "package": "black",
+          "package_or_url": "black",
+          "package_version": "20.8b1"
+        },
+        "pipx_metadata_version": "0.2",
+        "python_version": "Python 3.9.1"
+      }
+    },
+    "poetry": {
+      "metadata": {
+        "main_package": {
+          "package": "poetry",
+          "package_or_url": "poetry",
+          "package_version": "1.1.2"
+        }
+      }
+    },
+    "mytool": {
+      "metadata": {
+        "main_package": {
+          "package": "mytool",
+          "package_or_url": "git+https://example.com/user/mytool.git",
+          "package_version": "0.1.0"
+        }
+      }
+    }
+  }
+}`
+	installed, err := parsePipxList(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(installed) != 2 {
+		t.Fatalf("expected 2 PyPI packages, got %v", installed)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/pypi/black/json":
+			fmt.Fprint(w, `{"info":{"name":"black","version":"20.8b1"}}`)
+		case "/pypi/poetry/json":
+			fmt.Fprint(w, `{"info":{"name":"poetry","version":"1.1.4"}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+	b := &pipxBackend{registry: ts.URL + "/pypi"}
+	actual, err := b.checkRegistry(context.Background(), installed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := api.UpdatesList{
+		{
+			Pkg:    "poetry",
+			OldVer: "1.1.2",
+			NewVer: "1.1.4",
+			Repo:   "pypi",
+			Scope:  "user",
+		},
+	}
+	if len(actual) != len(expected) {
+		t.Fatalf("expected %d updates, got %d: %v", len(expected), len(actual), actual)
+	}
+	for i, a := range actual {
+		if e := expected[i]; !a.Equals(&e) {
+			t.Errorf("expected %v, got %v", e, a)
+		}
+	}
+}
@@ -12,14 +12,40 @@ pgdg-fedora-repo.noarch                                                42.0-6
 pgdg-fedora-repo.noarch                                                42.0-6                                                 pgdg95
 pgdg-fedora-repo.noarch                                                42.0-6                                                 pgdg94
 
+$ rpm -q --qf '%{NAME}.%{ARCH} %|EPOCH?{%{EPOCH}:}:{}|%{VERSION}-%{RELEASE} %{SIZE}\n' samba.x86_64 kernel-core.x86_64 foo.noarch
+samba.x86_64 2:4.13.0-0.fc33 2287635
+kernel-core.x86_64 5.8.15-301.fc33 74104588
+kernel-core.x86_64 5.8.16-300.fc33 74121716
+package foo.noarch is not installed
+
+$ dnf -q repoquery --upgrades --latest-limit 1 --qf '%{name}.%{arch} %{downloadsize} %{installsize}'
+samba.x86_64 1156744 2291731
+kernel-core.x86_64 33415772 74186580
+
+$ dnf -q updateinfo list --updates
+FEDORA-2020-1a2b3c4d5e enhancement   python3-pip-20.2.2-1.fc33.noarch
+FEDORA-2020-9f8e7d6c5b Important/Sec. openssl-libs-1:1.1.1i-1.fc33.x86_64
+
+$ dnf -q updateinfo info --updates
+===============================================================================
+  openssl-1.1.1i-1.fc33
+===============================================================================
+  Update ID: FEDORA-2020-9f8e7d6c5b
+       Type: security
+       CVEs: CVE-2020-1971
+           : CVE-2020-1967
+   Severity: Important
+
 */
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"os"
 	"os/exec"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
@@ -37,13 +63,92 @@ const oldDnfTimeFmt = "2006-01-02T15:04:05Z0700"
 // Group 4: repo
 var reYum = regexp.MustCompile(`(?m)^\s*(\S+)(\.\S+)\s+(\S+)\s+(\S+)\s*$`)
 
+// Group 1: advisory ID
+// Group 2: type, <severity>/Sec. for security advisories
+// Group 3: <name>-[<epoch>:]<version>-<release>.<arch>
+var reDnfUpdateinfoList = regexp.MustCompile(`(?m)^(\S+)\s+(\S+)\s+(\S+)\s*$`)
+
+// Group 1: key, empty if the value continues the previous key
+// Group 2: value
+var reDnfUpdateinfoInfo = regexp.MustCompile(`^\s*([\w ]*?)\s*: (.*)$`)
+
+// rpmQueryFormat prints <name>.<arch> [<epoch>:]<version>-<release> <installed size>,
+// the epoch is omitted if unset like dnf does
+const rpmQueryFormat = `%{NAME}.%{ARCH} %|EPOCH?{%{EPOCH}:}:{}|%{VERSION}-%{RELEASE} %{SIZE}\n`
+
+// dnfRepoqueryFormat prints <name>.<arch> <download size> <installed size>
+const dnfRepoqueryFormat = `%{name}.%{arch} %{downloadsize} %{installsize}`
+
+// rpmQueryBatch is the maximum number of packages queried by a single rpm command
+const rpmQueryBatch = 500
+
+// Group 1: <name>.<arch>
+// Group 2: [<epoch>:]<version>-<release>
+// Group 3: installed size
+var reRpmQuery = regexp.MustCompile(`(?m)^(\S+\.\S+) (\S+-\S+) (\d+)$`)
+
+// Group 1: <name>.<arch>
+// Group 2: download size
+// Group 3: installed size
+var reDnfRepoquery = regexp.MustCompile(`(?m)^(\S+\.\S+) (\d+) (\d+)$`)
+
+// rpmPackage is an installed package from rpm -q
+type rpmPackage struct {
+	evr  string
+	size int64
+}
+
+// dnfAdvisory holds the details of an advisory from dnf updateinfo
+type dnfAdvisory struct {
+	id       string
+	category string
+	severity string
+	cves     []string
+	// Package version without epoch, only set by parseDnfUpdateinfoList
+	ver string
+}
+
 // Group 1: timestamp
 // Group 2: action (Installed, Upgrade, Upgraded, Erase)
 // Group 3: <package>-<version>.<os>.<arch>
 var reDnfLog = regexp.MustCompile(`^(\S+)\s+SUBDEBUG\s+(\w+):\s+(\S+)$`)
 
-func runYum(name string) (retStr string, err error) {
-	retStr, err = runCmd(name, "-e0", "-d0", "check-update")
+// dnfBackend checks for updates with dnf, falling back to yum
+type dnfBackend struct {
+	logFp string
+}
+
+func init() {
+	registerBackend(&dnfBackend{logFp: "/var/log/dnf.rpm.log"})
+}
+
+func (b *dnfBackend) Name() string { return "dnf" }
+
+func (b *dnfBackend) Detect(ctx context.Context, distro string) bool {
+	switch distro {
+	case "fedora", "centos", "rhel", "ol":
+		return true
+	}
+	return false
+}
+
+func (b *dnfBackend) Check(ctx context.Context) (api.UpdatesList, error) { return UpdateDnf(ctx) }
+
+func (b *dnfBackend) LogPaths() []string {
+	if b.logFp == "" {
+		return nil
+	}
+	return []string{b.logFp}
+}
+
+func (b *dnfBackend) ParseLog(ctx context.Context, fp string, f *api.File) error {
+	return checkDnfLogs(fp, f)
+}
+
+func (b *dnfBackend) Capabilities() Capability { return CapOldVersion | CapRepo | CapLogs }
+
+func runYum(ctx context.Context, name string) (retStr string, err error) {
+	retStr, err = runCmd(ctx, name, "-e0", "-d0", "check-update")
 	if err != nil {
 		// DNF returns code 100 if there are updates
 		if exitError, ok := err.(*exec.ExitError); ok {
@@ -58,18 +163,245 @@ func runYum(name string) (retStr string, err error) {
 	return
 }
 
-// UpdateDnf uses dnf or yum to get available updates
-func UpdateDnf() (api.UpdatesList, error) {
-	rawOut, err := runYum("dnf")
+// UpdateDnf uses dnf or yum to get available updates, installed versions are queried
+// from rpm, advisories and sizes are added if available
+func UpdateDnf(ctx context.Context) (api.UpdatesList, error) {
+	name := "dnf"
+	rawOut, err := runYum(ctx, name)
 	// Try yum instead
 	if err != nil {
-		rawOut, err = runYum("yum")
+		name = "yum"
+		rawOut, err = runYum(ctx, name)
 	}
 	// Both failed
 	if err != nil {
 		return api.UpdatesList{}, err
 	}
-	return parseYumCheckUpdate(rawOut), nil
+	updates := parseYumCheckUpdate(rawOut)
+	if len(updates) > 0 {
+		installed, err := addRpmInstalled(ctx, updates)
+		if err != nil {
+			log.Warnf("UpdateDnf: cannot get installed versions: %v", err)
+		}
+		if err := addDnfAdvisories(ctx, name, updates); err != nil {
+			log.Warnf("UpdateDnf: cannot get advisories: %v", err)
+		}
+		// yum has no repoquery command
+		if name == "dnf" {
+			if err := addDnfSizes(ctx, updates, installed); err != nil {
+				log.Warnf("UpdateDnf: cannot get sizes: %v", err)
+			}
+		}
+	}
+	return updates, nil
+}
+
+// addDnfAdvisories sets advisory details of updates using updateinfo
+func addDnfAdvisories(ctx context.Context, name string, updates api.UpdatesList) error {
+	// yum only accepts the old positional argument
+	avail := "--updates"
+	if name == "yum" {
+		avail = "updates"
+	}
+	rawList, err := runCmd(ctx, name, "-q", "updateinfo", "list", avail)
+	if err != nil {
+		return err
+	}
+	rawInfo, err := runCmd(ctx, name, "-q", "updateinfo", "info", avail)
+	if err != nil {
+		return err
+	}
+	setDnfAdvisories(updates, parseDnfUpdateinfoList(rawList), parseDnfUpdateinfoInfo(rawInfo))
+	return nil
+}
+
+// addRpmInstalled sets the installed version of updates and returns the installed packages
+//
+// Packages are queried in batches, rpm exits with 1 if any of them is not installed so that is not an error.
+func addRpmInstalled(ctx context.Context, updates api.UpdatesList) (map[string]rpmPackage, error) {
+	installed := make(map[string]rpmPackage)
+	for start := 0; start < len(updates); start += rpmQueryBatch {
+		end := start + rpmQueryBatch
+		if end > len(updates) {
+			end = len(updates)
+		}
+		cmdArgs := []string{"-q", "--qf", rpmQueryFormat}
+		for _, u := range updates[start:end] {
+			cmdArgs = append(cmdArgs, u.Pkg+"."+u.Arch)
+		}
+		out, err := runCmd(ctx, "rpm", cmdArgs...)
+		if err != nil {
+			if exitError, ok := err.(*exec.ExitError); !ok || exitError.ExitCode() != 1 {
+				return installed, err
+			}
+		}
+		for k, v := range parseRpmQuery(out) {
+			installed[k] = v
+		}
+	}
+	for i, u := range updates {
+		if p, ok := installed[u.Pkg+"."+u.Arch]; ok {
+			updates[i].OldVer = p.evr
+		}
+	}
+	return installed, nil
+}
+
+// parseRpmQuery returns a map of <name>.<arch> -> installed package, the newest is kept if
+// several are installed, e.g. kernels
+func parseRpmQuery(out string) map[string]rpmPackage {
+	ret := make(map[string]rpmPackage)
+	for _, m := range reRpmQuery.FindAllStringSubmatch(out, -1) {
+		if cur, ok := ret[m[1]]; !ok || vercmpRpm(m[2], cur.evr) > 0 {
+			size, _ := strconv.ParseInt(m[3], 10, 64)
+			ret[m[1]] = rpmPackage{evr: m[2], size: size}
+		}
+	}
+	return ret
+}
+
+// addDnfSizes sets the download size of updates and the change in installed size if the
+// installed package is known
+func addDnfSizes(ctx context.Context, updates api.UpdatesList, installed map[string]rpmPackage) error {
+	out, err := runCmd(ctx, "dnf", "-q", "repoquery", "--upgrades", "--latest-limit", "1", "--qf", dnfRepoqueryFormat)
+	if err != nil {
+		return err
+	}
+	setDnfSizes(updates, installed, out)
+	return nil
+}
+
+// setDnfSizes sets sizes of updates from repoquery output
+func setDnfSizes(updates api.UpdatesList, installed map[string]rpmPackage, out string) {
+	sizes := make(map[string][2]int64)
+	for _, m := range reDnfRepoquery.FindAllStringSubmatch(out, -1) {
+		download, _ := strconv.ParseInt(m[2], 10, 64)
+		install, _ := strconv.ParseInt(m[3], 10, 64)
+		sizes[m[1]] = [2]int64{download, install}
+	}
+	for i, u := range updates {
+		key := u.Pkg + "." + u.Arch
+		s, ok := sizes[key]
+		if !ok {
+			continue
+		}
+		updates[i].DownloadSize = s[0]
+		if p, ok := installed[key]; ok && s[1] > 0 {
+			updates[i].InstallSize = s[1] - p.size
+		}
+	}
+}
+
+// splitRpmNevra splits <name>-[<epoch>:]<version>-<release>.<arch> into name, [<epoch>:]<version>-<release> and arch
+func splitRpmNevra(nevra string) (name string, evr string, arch string) {
+	if i := strings.LastIndex(nevra, "."); i > 0 {
+		nevra, arch = nevra[:i], nevra[i+1:]
+	}
+	// Release
+	i := strings.LastIndex(nevra, "-")
+	if i < 1 {
+		return nevra, "", arch
+	}
+	// Version
+	j := strings.LastIndex(nevra[:i], "-")
+	if j < 1 {
+		return nevra, "", arch
+	}
+	return nevra[:j], nevra[j+1:], arch
+}
+
+// stripEpoch returns version without the epoch prefix
+func stripEpoch(ver string) string {
+	if i := strings.Index(ver, ":"); i >= 0 {
+		return ver[i+1:]
+	}
+	return ver
+}
+
+// parseDnfUpdateinfoList returns a map of package name -> advisories, security advisories come first
+func parseDnfUpdateinfoList(out string) map[string][]dnfAdvisory {
+	ret := make(map[string][]dnfAdvisory)
+	for _, m := range reDnfUpdateinfoList.FindAllStringSubmatch(out, -1) {
+		a := dnfAdvisory{id: m[1], category: strings.ToLower(m[2])}
+		if strings.HasSuffix(m[2], "/Sec.") {
+			a.category = "security"
+			a.severity = strings.ToLower(strings.TrimSuffix(m[2], "/Sec."))
+			if a.severity == "unknown" || a.severity == "none" {
+				a.severity = ""
+			}
+		}
+		name, evr, _ := splitRpmNevra(m[3])
+		a.ver = stripEpoch(evr)
+		if a.category == "security" {
+			ret[name] = append([]dnfAdvisory{a}, ret[name]...)
+		} else {
+			ret[name] = append(ret[name], a)
+		}
+	}
+	return ret
+}
+
+// parseDnfUpdateinfoInfo returns a map of advisory ID -> advisory, only ID, type, severity and CVEs are read
+func parseDnfUpdateinfoInfo(out string) map[string]dnfAdvisory {
+	ret := make(map[string]dnfAdvisory)
+	var cur *dnfAdvisory
+	var key string
+	save := func() {
+		if cur != nil {
+			ret[cur.id] = *cur
+		}
+	}
+	for _, line := range strings.Split(out, "\n") {
+		m := reDnfUpdateinfoInfo.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		if m[1] != "" {
+			key = m[1]
+		}
+		value := strings.TrimSpace(m[2])
+		switch key {
+		case "Update ID":
+			save()
+			cur = &dnfAdvisory{id: value}
+		case "Type":
+			if cur != nil {
+				cur.category = strings.ToLower(value)
+			}
+		case "Severity":
+			if cur != nil && value != "None" && value != "Unknown" {
+				cur.severity = strings.ToLower(value)
+			}
+		case "CVEs":
+			if cur != nil {
+				cur.cves = append(cur.cves, strings.Fields(value)...)
+			}
+		}
+	}
+	save()
+	return ret
+}
+
+// setDnfAdvisories sets advisory details of updates with a matching name and version
+func setDnfAdvisories(updates api.UpdatesList, list map[string][]dnfAdvisory, info map[string]dnfAdvisory) {
+	for i, u := range updates {
+		for _, a := range list[u.Pkg] {
+			if a.ver != stripEpoch(u.NewVer) {
+				continue
+			}
+			if details, ok := info[a.id]; ok {
+				if details.severity != "" {
+					a.severity = details.severity
+				}
+				a.cves = details.cves
+			}
+			updates[i].Advisory = a.id
+			updates[i].Category = a.category
+			updates[i].Severity = a.severity
+			updates[i].CVEs = a.cves
+			break
+		}
+	}
 }
 
 func parseYumCheckUpdate(out string) api.UpdatesList {
@@ -85,24 +417,25 @@ func parseYumCheckUpdate(out string) api.UpdatesList {
 			Pkg:    m[1],
 			NewVer: m[3],
 			Repo:   m[4],
+			Arch:   strings.TrimPrefix(m[2], "."),
 		})
 	}
 	return updates
 }
 
-// checkDnfLogs read dnf.rpm log file and update internal cache accordingly
-func checkDnfLogs(fp string) error {
+// checkDnfLogs read dnf.rpm log file and update f accordingly
+func checkDnfLogs(fp string, f *api.File) error {
 	file, err := os.Open(fp)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
 	scanner := bufio.NewScanner(file)
-	lastChecked, err := time.Parse(time.RFC3339, cache.f.Checked)
+	lastChecked, err := time.Parse(time.RFC3339, f.Checked)
 	if err != nil {
-		return fmt.Errorf("cannot parse cached time '%s': %v", cache.f.Checked, err)
+		return fmt.Errorf("cannot parse cached time '%s': %v", f.Checked, err)
 	}
-	beforeLen := len(cache.f.Updates)
+	beforeLen := len(f.Updates)
 	for scanner.Scan() {
 		m := reDnfLog.FindStringSubmatch(scanner.Text())
 		if len(m) != 4 {
@@ -126,21 +459,21 @@ func checkDnfLogs(fp string) error {
 			log.Debugf("skip '%s', action installed", name)
 			continue
 		case "Upgrade": // Upgraded shows the old version
-			if changed := cache.f.RemoveContains(name, true); changed {
+			if changed := f.RemoveContains(name, true); changed {
 				log.Debugf("removed upgraded package %s", name)
 			} else {
 				log.Debugf("skip upgraded package %s", name)
 			}
 		case "Erase":
-			if changed := cache.f.RemoveContains(name, false); changed {
+			if changed := f.RemoveContains(name, false); changed {
 				log.Debugf("removed uninstalled package %s", name)
 			} else {
 				log.Debugf("skip uninstalled package %s", name)
 			}
 		}
 	}
-	if len(cache.f.Updates) != beforeLen {
-		log.Infof("%s: removed %d pending updates", fp, beforeLen-len(cache.f.Updates))
+	if len(f.Updates) != beforeLen {
+		log.Infof("%s: removed %d pending updates", fp, beforeLen-len(f.Updates))
 	}
 	return scanner.Err()
 }
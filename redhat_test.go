@@ -3,6 +3,7 @@ package main
 import (
 	"encoding/json"
 	"io/ioutil"
+	"strings"
 	"testing"
 
 	log "github.com/sirupsen/logrus"
@@ -121,6 +122,9 @@ samba-common.noarch                                                       2:4.13
 		},
 	}
 	checkRedHatParse(t, expected, actual)
+	if actual[0].Arch != "x86_64" || actual[3].Arch != "noarch" {
+		t.Errorf("expected architectures x86_64 and noarch, got %s and %s", actual[0].Arch, actual[3].Arch)
+	}
 	// Test when Obsoleting Packages is present
 	out = `
 kernel-core.x86_64                                                   5.8.18-300.fc33                                             updates
@@ -319,6 +323,28 @@ func TestRedHatDnfLogs(t *testing.T) {
 	if len(cache.f.Updates) != 0 {
 		t.Errorf("Expected 0 updates, got %d: %v", len(cache.f.Updates), cache.f.Updates)
 	}
+	// Only the upgraded architecture is removed
+	file = `
+2020-12-20T12:10:42+0100 SUBDEBUG Upgrade: glibc-2.32-3.fc33.i686
+`
+	cache.f.Updates = api.UpdatesList{
+		{
+			Pkg:    "glibc",
+			NewVer: "2.32-3.fc33",
+			Arch:   "x86_64",
+		},
+		{
+			Pkg:    "glibc",
+			NewVer: "2.32-3.fc33",
+			Arch:   "i686",
+		},
+	}
+	if err := runDnfLogsTest(file); err != nil {
+		t.Fatal(err)
+	}
+	if len(cache.f.Updates) != 1 || cache.f.Updates[0].Arch != "x86_64" {
+		t.Errorf("Expected glibc.x86_64 update, got %v", cache.f.Updates)
+	}
 }
 
 func runDnfLogsTest(content string) error {
@@ -327,9 +353,134 @@ func runDnfLogsTest(content string) error {
 	if err != nil {
 		return err
 	}
-	err = checkDnfLogs(fp)
+	err = checkDnfLogs(fp, &cache.f)
 	if err != nil {
 		return err
 	}
 	return nil
 }
+
+func TestRedHatRpmQuery(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+	out := `samba.x86_64 2:4.13.0-0.fc33 2287635
+glibc.x86_64 2.32-1.fc33 6427846
+glibc.i686 2.32-2.fc33 5934112
+kernel-core.x86_64 5.8.16-300.fc33 74121716
+kernel-core.x86_64 5.8.15-301.fc33 74104588
+package foo.noarch is not installed
+`
+	actual := parseRpmQuery(out)
+	expected := map[string]rpmPackage{
+		"samba.x86_64":       {evr: "2:4.13.0-0.fc33", size: 2287635},
+		"glibc.x86_64":       {evr: "2.32-1.fc33", size: 6427846},
+		"glibc.i686":         {evr: "2.32-2.fc33", size: 5934112},
+		"kernel-core.x86_64": {evr: "5.8.16-300.fc33", size: 74121716},
+	}
+	if len(actual) != len(expected) {
+		t.Fatalf("expected %d packages, got %d: %v", len(expected), len(actual), actual)
+	}
+	for k, v := range expected {
+		if actual[k] != v {
+			t.Errorf("%s: expected %v, got %v", k, v, actual[k])
+		}
+	}
+	updates := api.UpdatesList{
+		{Pkg: "glibc", NewVer: "2.32-3.fc33", Arch: "x86_64"},
+		{Pkg: "glibc", NewVer: "2.32-3.fc33", Arch: "i686"},
+		{Pkg: "kernel-core", NewVer: "5.9.16-200.fc33", Arch: "x86_64"},
+		{Pkg: "python3-pip", NewVer: "20.2.2-1.fc33", Arch: "noarch"},
+	}
+	setDnfSizes(updates, actual, `glibc.x86_64 3775136 6431942
+glibc.i686 3962412 5930016
+kernel-core.x86_64 33415772 74186580
+python3-pip.noarch 2236856 11393457
+`)
+	for i, e := range [][2]int64{{3775136, 4096}, {3962412, -4096}, {33415772, 64864}, {2236856, 0}} {
+		if updates[i].DownloadSize != e[0] || updates[i].InstallSize != e[1] {
+			t.Errorf("%s.%s: expected sizes %v, got %d %d", updates[i].Pkg, updates[i].Arch, e,
+				updates[i].DownloadSize, updates[i].InstallSize)
+		}
+	}
+}
+
+func TestRedHatDnfAdvisories(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+	list := `FEDORA-2020-1a2b3c4d5e enhancement   python3-pip-20.2.2-1.fc33.noarch
+FEDORA-2020-9f8e7d6c5b Important/Sec. openssl-libs-1:1.1.1i-1.fc33.x86_64
+FEDORA-2020-0a1b2c3d4e bugfix        openssl-libs-1:1.1.1i-1.fc33.x86_64
+FEDORA-2020-5f6e7d8c9b bugfix        kernel-core-5.9.16-200.fc33.x86_64
+FEDORA-2020-3c4d5e6f7a Unknown/Sec.  kernel-core-5.9.15-200.fc33.x86_64
+`
+	info := `===============================================================================
+  python-pip-20.2.2-1.fc33
+===============================================================================
+  Update ID: FEDORA-2020-1a2b3c4d5e
+       Type: enhancement
+    Updated: 2020-12-10 01:23:45
+Description: Update to 20.2.2
+   Severity: None
+
+===============================================================================
+  openssl-1.1.1i-1.fc33
+===============================================================================
+  Update ID: FEDORA-2020-9f8e7d6c5b
+       Type: security
+    Updated: 2020-12-11 01:23:45
+       Bugs: 1903409 - CVE-2020-1971 openssl: EDIPARTYNAME NULL pointer de-reference
+       CVEs: CVE-2020-1971
+           : CVE-2020-1967
+Description: Update to 1.1.1i
+           : Fixes two vulnerabilities
+   Severity: Important
+`
+	updates := api.UpdatesList{
+		{
+			Pkg:    "python3-pip",
+			NewVer: "20.2.2-1.fc33",
+			Repo:   "updates",
+		},
+		{
+			Pkg:    "openssl-libs",
+			NewVer: "1:1.1.1i-1.fc33",
+			Repo:   "updates",
+		},
+		{
+			Pkg:    "kernel-core",
+			NewVer: "5.9.16-200.fc33",
+			Repo:   "updates",
+		},
+		{
+			Pkg:    "vim-minimal",
+			NewVer: "2:8.2.2143-1.fc33",
+			Repo:   "updates",
+		},
+	}
+	setDnfAdvisories(updates, parseDnfUpdateinfoList(list), parseDnfUpdateinfoInfo(info))
+	expected := api.UpdatesList{
+		{
+			Advisory: "FEDORA-2020-1a2b3c4d5e",
+			Category: "enhancement",
+		},
+		{
+			Advisory: "FEDORA-2020-9f8e7d6c5b",
+			Category: "security",
+			Severity: "important",
+			CVEs:     []string{"CVE-2020-1971", "CVE-2020-1967"},
+		},
+		{
+			Advisory: "FEDORA-2020-5f6e7d8c9b",
+			Category: "bugfix",
+		},
+		{},
+	}
+	for i, u := range updates {
+		e := expected[i]
+		if u.Advisory != e.Advisory || u.Category != e.Category || u.Severity != e.Severity {
+			t.Errorf("%s: expected %s %s %s, got %s %s %s", u.Pkg,
+				e.Advisory, e.Category, e.Severity, u.Advisory, u.Category, u.Severity)
+		}
+		if strings.Join(u.CVEs, ",") != strings.Join(e.CVEs, ",") {
+			t.Errorf("%s: expected CVEs %v, got %v", u.Pkg, e.CVEs, u.CVEs)
+		}
+	}
+}
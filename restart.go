@@ -0,0 +1,245 @@
+package main
+
+/*
+$ uname -r
+5.9.16-200.fc33.x86_64
+
+$ ls /lib/modules
+5.9.16-200.fc33.x86_64  5.10.6-200.fc33.x86_64
+
+$ needs-restarting -r
+Core libraries or services have been updated since boot-up:
+  * kernel
+
+Reboot is required to fully utilize these updates.
+More information: https://access.redhat.com/solutions/27943
+$ echo $?
+1
+
+$ needs-restarting -s
+sshd.service
+NetworkManager.service
+
+$ grep '(deleted)' /proc/812/maps
+7f3c2a1e4000-7f3c2a20a000 r--p 00000000 00:1f 1234567    /usr/lib64/libssl.so.1.1.1g (deleted)
+
+$ cat /proc/812/cgroup
+0::/system.slice/sshd.service
+*/
+
+import (
+	"bufio"
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// restartChecker detects if a reboot is needed or services must be restarted after upgrades
+type restartChecker struct {
+	// Directory with kernel modules, one subdirectory per installed kernel
+	modulesDir string
+	// Created by Debian and Ubuntu packages which need a reboot
+	rebootRequiredFp string
+	procDir          string
+	// Returns the running kernel release, uname -r
+	kernelRelease func() (string, error)
+	// True if needs-restarting from dnf-utils is available, set by Detect
+	needsRestarting bool
+}
+
+func newRestartChecker() *restartChecker {
+	return &restartChecker{
+		modulesDir:       "/lib/modules",
+		rebootRequiredFp: "/var/run/reboot-required",
+		procDir:          "/proc",
+		kernelRelease:    unameRelease,
+	}
+}
+
+// Detect looks for the commands used by Check, it should be called once before checking
+func (r *restartChecker) Detect() {
+	r.needsRestarting = checkCmd("needs-restarting")
+	log.Debugf("restartChecker.Detect: needs-restarting %v", r.needsRestarting)
+}
+
+// unameRelease returns the kernel release like uname -r
+func unameRelease() (string, error) {
+	var uts unix.Utsname
+	if err := unix.Uname(&uts); err != nil {
+		return "", err
+	}
+	return unix.ByteSliceToString(uts.Release[:]), nil
+}
+
+// Check returns true if a reboot is required and the sorted list of services which should be restarted
+//
+// Processes which are not part of a systemd service are listed by their command name.
+func (r *restartChecker) Check(ctx context.Context) (reboot bool, services []string) {
+	reboot = r.kernelOutdated() || checkFileExists(r.rebootRequiredFp)
+	found := make(map[string]bool)
+	// needs-restarting -s looks for deleted libraries as well, only scan processes ourselves without it
+	scanProcesses := true
+	if r.needsRestarting {
+		// Exit code 1 means a reboot is required
+		_, err := runCmd(ctx, "needs-restarting", "-r")
+		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+			reboot = true
+		}
+		if out, err := runCmd(ctx, "needs-restarting", "-s"); err == nil {
+			for _, line := range strings.Split(out, "\n") {
+				if line = strings.TrimSpace(line); line != "" {
+					found[line] = true
+				}
+			}
+			scanProcesses = false
+		} else {
+			log.Debugf("restartChecker.Check: needs-restarting -s: %v", err)
+		}
+	}
+	if scanProcesses {
+		for _, s := range r.deletedLibraryUsers() {
+			found[s] = true
+		}
+	}
+	services = make([]string, 0, len(found))
+	for s := range found {
+		services = append(services, s)
+	}
+	sort.Strings(services)
+	return reboot, services
+}
+
+// kernelSkeleton removes digits from a kernel release so that releases of the same flavour can be compared
+//
+// e.g. 5.10.6-200.fc33.x86_64 -> ..-.fc.x_
+func kernelSkeleton(release string) string {
+	return strings.Map(func(c rune) rune {
+		if c >= '0' && c <= '9' {
+			return -1
+		}
+		return c
+	}, release)
+}
+
+// kernelOutdated returns true if the modules of the running kernel were removed,
+// or if a newer kernel of the same flavour is installed
+func (r *restartChecker) kernelOutdated() bool {
+	running, err := r.kernelRelease()
+	if err != nil {
+		log.Debugf("restartChecker.kernelOutdated: %v", err)
+		return false
+	}
+	entries, err := ioutil.ReadDir(r.modulesDir)
+	if err != nil {
+		// No modules directory at all, e.g. in containers
+		log.Debugf("restartChecker.kernelOutdated: %v", err)
+		return false
+	}
+	skeleton := kernelSkeleton(running)
+	foundRunning := false
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		if e.Name() == running {
+			foundRunning = true
+			continue
+		}
+		if kernelSkeleton(e.Name()) == skeleton && alpmSegmentCmp(e.Name(), running) > 0 {
+			log.Debugf("restartChecker.kernelOutdated: running %s, installed %s", running, e.Name())
+			return true
+		}
+	}
+	if !foundRunning {
+		log.Debugf("restartChecker.kernelOutdated: running %s, modules not found", running)
+		return true
+	}
+	return false
+}
+
+// deletedLibraryUsers returns the services or commands of processes which map deleted shared libraries
+//
+// Processes which cannot be read, usually due to insufficient permissions, are skipped.
+func (r *restartChecker) deletedLibraryUsers() []string {
+	dirs, err := ioutil.ReadDir(r.procDir)
+	if err != nil {
+		log.Debugf("restartChecker.deletedLibraryUsers: %v", err)
+		return nil
+	}
+	found := make(map[string]bool)
+	ret := make([]string, 0)
+	for _, d := range dirs {
+		if !d.IsDir() || !isNumeric(d.Name()) {
+			continue
+		}
+		pidDir := filepath.Join(r.procDir, d.Name())
+		if !mapsDeletedLibrary(filepath.Join(pidDir, "maps")) {
+			continue
+		}
+		name := processService(pidDir)
+		if name == "" || found[name] {
+			continue
+		}
+		log.Debugf("restartChecker.deletedLibraryUsers: %s (pid %s) maps a deleted library", name, d.Name())
+		found[name] = true
+		ret = append(ret, name)
+	}
+	return ret
+}
+
+// mapsDeletedLibrary returns true if a /proc/<pid>/maps file contains a deleted shared library
+func mapsDeletedLibrary(fp string) bool {
+	file, err := os.Open(fp)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasSuffix(line, " (deleted)") {
+			continue
+		}
+		// The path starts at the first slash, after the inode
+		i := strings.Index(line, "/")
+		if i < 0 {
+			continue
+		}
+		path := strings.TrimSuffix(line[i:], " (deleted)")
+		if strings.Contains(filepath.Base(path), ".so") {
+			return true
+		}
+	}
+	return false
+}
+
+// processService returns the systemd service a process belongs to, or its command name if it has none
+func processService(pidDir string) string {
+	if raw, err := ioutil.ReadFile(filepath.Join(pidDir, "cgroup")); err == nil {
+		for _, line := range strings.Split(string(raw), "\n") {
+			// hierarchy-ID:controller-list:cgroup-path, the innermost service wins
+			cols := strings.SplitN(line, ":", 3)
+			if len(cols) != 3 {
+				continue
+			}
+			parts := strings.Split(cols[2], "/")
+			for i := len(parts) - 1; i >= 0; i-- {
+				if strings.HasSuffix(parts[i], ".service") {
+					return parts[i]
+				}
+			}
+		}
+	}
+	raw, err := ioutil.ReadFile(filepath.Join(pidDir, "comm"))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(raw))
+}
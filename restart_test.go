@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func writeTestFile(t *testing.T, fp string, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(fp), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(fp, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRestartKernelOutdated(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+	tmp, err := ioutil.TempDir("", "test_restart")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	r := &restartChecker{
+		modulesDir: filepath.Join(tmp, "modules"),
+		kernelRelease: func() (string, error) {
+			return "5.9.16-200.fc33.x86_64", nil
+		},
+	}
+	// No modules directory
+	if r.kernelOutdated() {
+		t.Error("expected no reboot without modules directory")
+	}
+	for _, d := range []string{"5.9.16-200.fc33.x86_64", "5.8.18-300.fc33.x86_64", "5.10.0-0.rc7.git0.1.vanilla.1.fc33.x86_64"} {
+		if err := os.MkdirAll(filepath.Join(r.modulesDir, d), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if r.kernelOutdated() {
+		t.Error("expected no reboot with running kernel as the latest of its flavour")
+	}
+	if err := os.Mkdir(filepath.Join(r.modulesDir, "5.10.6-200.fc33.x86_64"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if !r.kernelOutdated() {
+		t.Error("expected reboot with newer kernel installed")
+	}
+	// Arch removes the modules of the old kernel
+	r.kernelRelease = func() (string, error) { return "5.10.4-arch2-1", nil }
+	if !r.kernelOutdated() {
+		t.Error("expected reboot with running kernel modules missing")
+	}
+}
+
+func TestRestartCheck(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+	tmp, err := ioutil.TempDir("", "test_restart")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	r := &restartChecker{
+		modulesDir:       filepath.Join(tmp, "modules"),
+		rebootRequiredFp: filepath.Join(tmp, "reboot-required"),
+		procDir:          filepath.Join(tmp, "proc"),
+		kernelRelease: func() (string, error) {
+			return "5.10.0-1-amd64", nil
+		},
+	}
+	if err := os.MkdirAll(filepath.Join(r.modulesDir, "5.10.0-1-amd64"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	const deleted = "7f3c2a1e4000-7f3c2a20a000 r--p 00000000 00:1f 1234567                    /usr/lib/x86_64-linux-gnu/libssl.so.1.1 (deleted)\n"
+	const current = "7f3c2a20a000-7f3c2a25a000 r-xp 00026000 00:1f 1234568                    /usr/lib/x86_64-linux-gnu/libc-2.31.so\n"
+	// Service with a deleted library
+	writeTestFile(t, filepath.Join(r.procDir, "812", "maps"), current+deleted)
+	writeTestFile(t, filepath.Join(r.procDir, "812", "cgroup"), "0::/system.slice/sshd.service\n")
+	writeTestFile(t, filepath.Join(r.procDir, "812", "comm"), "sshd\n")
+	// Second process of the same service
+	writeTestFile(t, filepath.Join(r.procDir, "813", "maps"), deleted)
+	writeTestFile(t, filepath.Join(r.procDir, "813", "cgroup"), "1:name=systemd:/system.slice/sshd.service\n")
+	// Session process without a service
+	writeTestFile(t, filepath.Join(r.procDir, "1500", "maps"), deleted)
+	writeTestFile(t, filepath.Join(r.procDir, "1500", "cgroup"), "0::/user.slice/user-1000.slice/session-2.scope\n")
+	writeTestFile(t, filepath.Join(r.procDir, "1500", "comm"), "tmux: server\n")
+	// Deleted file which isn't a library
+	writeTestFile(t, filepath.Join(r.procDir, "1600", "maps"), current+"7f3c2a1e4000-7f3c2a20a000 rw-s 00000000 00:01 4096 /memfd:pulseaudio (deleted)\n")
+	writeTestFile(t, filepath.Join(r.procDir, "1600", "cgroup"), "0::/user.slice/user-1000.slice/user@1000.service/app.slice/pulseaudio.service\n")
+	// Not a process
+	writeTestFile(t, filepath.Join(r.procDir, "self", "maps"), deleted)
+
+	reboot, services := r.Check(context.Background())
+	if reboot {
+		t.Error("expected no reboot")
+	}
+	expected := []string{"sshd.service", "tmux: server"}
+	if !reflect.DeepEqual(services, expected) {
+		t.Errorf("expected %v, got %v", expected, services)
+	}
+	writeTestFile(t, r.rebootRequiredFp, "*** System restart required ***\n")
+	if reboot, _ = r.Check(context.Background()); !reboot {
+		t.Error("expected reboot with reboot-required file")
+	}
+}
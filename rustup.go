@@ -0,0 +1,91 @@
+package main
+
+/*
+$ rustup check
+stable-x86_64-unknown-linux-gnu - Update available : 1.48.0 (7eac88abb 2020-11-16) -> 1.49.0 (e1884a8e3 2020-12-29)
+nightly-x86_64-unknown-linux-gnu - Up to date : 1.51.0-nightly (1f0fc02cc 2020-12-30)
+rustup - Update available : 1.23.0 -> 1.23.1
+*/
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/cosandr/go-check-updates/api"
+)
+
+// Group 1: toolchain name or rustup
+// Group 2: old version
+// Group 3: old commit and date (optional)
+// Group 4: new version
+// Group 5: new commit and date (optional)
+var reRustupCheck = regexp.MustCompile(`(?m)^(\S+) - Update available : (\S+)( \(.*?\))? -> (\S+)( \(.*?\))?\s*$`)
+
+// rustupBackend checks the current user's Rust toolchains, must be enabled explicitly
+type rustupBackend struct{}
+
+func init() {
+	registerBackend(&rustupBackend{})
+}
+
+func (b *rustupBackend) Name() string { return "rustup" }
+
+func (b *rustupBackend) Detect(ctx context.Context, distro string) bool { return checkCmd("rustup") }
+
+func (b *rustupBackend) Check(ctx context.Context) (api.UpdatesList, error) {
+	raw, err := runCmd(ctx, "rustup", "check")
+	if err != nil {
+		return api.UpdatesList{}, err
+	}
+	return parseRustupCheck(raw), nil
+}
+
+func (b *rustupBackend) LogPaths() []string { return nil }
+
+func (b *rustupBackend) ParseLog(ctx context.Context, fp string, f *api.File) error {
+	return fmt.Errorf("rustup has no log file")
+}
+
+func (b *rustupBackend) Capabilities() Capability { return CapOldVersion | CapRepo }
+
+func (b *rustupBackend) Optional() bool { return true }
+
+// rustupDate returns the date of a toolchain from its " (<commit> <date>)" suffix, empty if missing
+func rustupDate(s string) string {
+	fields := strings.Fields(strings.Trim(s, " ()"))
+	if len(fields) < 2 {
+		return ""
+	}
+	return fields[len(fields)-1]
+}
+
+// parseRustupCheck returns toolchains and rustup itself if a newer version is available
+//
+// The commit and date are only kept if the versions are the same, e.g. for nightly toolchains,
+// which are then compared by date.
+func parseRustupCheck(out string) api.UpdatesList {
+	updates := make(api.UpdatesList, 0)
+	for _, m := range reRustupCheck.FindAllStringSubmatch(out, -1) {
+		oldVer, newVer := m[2], m[4]
+		if c := vercmpSemver(newVer, oldVer); c < 0 {
+			continue
+		} else if c == 0 {
+			// Dates are YYYY-MM-DD
+			if rustupDate(m[5]) < rustupDate(m[3]) {
+				continue
+			}
+			oldVer += m[3]
+			newVer += m[5]
+		}
+		updates = append(updates, api.Update{
+			Pkg:    m[1],
+			OldVer: oldVer,
+			NewVer: newVer,
+			Repo:   "rustup",
+			Scope:  "user",
+		})
+	}
+	return updates
+}
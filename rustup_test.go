@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cosandr/go-check-updates/api"
+)
+
+func TestRustupParseCheck(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+	out := `stable-x86_64-unknown-linux-gnu - Update available : 1.48.0 (7eac88abb 2020-11-16) -> 1.49.0 (e1884a8e3 2020-12-29)
+beta-x86_64-unknown-linux-gnu - Up to date : 1.50.0-beta.1 (e1884a8e3 2020-12-29)
+nightly-x86_64-unknown-linux-gnu - Update available : 1.51.0-nightly (1f0fc02cc 2020-12-30) -> 1.51.0-nightly (44e3daf5e 2020-12-31)
+rustup - Update available : 1.23.0 -> 1.23.1
+1.48-x86_64-unknown-linux-gnu - Update available : 1.48.1 (7eac88abb 2020-11-16) -> 1.48.0 (7eac88abb 2020-11-16)
+`
+	actual := parseRustupCheck(out)
+	expected := api.UpdatesList{
+		{
+			Pkg:    "stable-x86_64-unknown-linux-gnu",
+			OldVer: "1.48.0",
+			NewVer: "1.49.0",
+			Repo:   "rustup",
+			Scope:  "user",
+		},
+		{
+			Pkg:    "nightly-x86_64-unknown-linux-gnu",
+			OldVer: "1.51.0-nightly (1f0fc02cc 2020-12-30)",
+			NewVer: "1.51.0-nightly (44e3daf5e 2020-12-31)",
+			Repo:   "rustup",
+			Scope:  "user",
+		},
+		{
+			Pkg:    "rustup",
+			OldVer: "1.23.0",
+			NewVer: "1.23.1",
+			Repo:   "rustup",
+			Scope:  "user",
+		},
+	}
+	if len(actual) != len(expected) {
+		t.Fatalf("expected %d updates, got %d: %v", len(expected), len(actual), actual)
+	}
+	for i, a := range actual {
+		if e := expected[i]; !a.Equals(&e) {
+			t.Errorf("expected %v, got %v", e, a)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+/*
+$ snap refresh --list
+Name   Version   Rev    Publisher   Notes
+core   16-2.48   10444  canonical✓  core
+lxd    4.8       18546  canonical✓  -
+
+$ snap list
+Name    Version    Rev    Tracking       Publisher   Notes
+core    16-2.47.1  10185  latest/stable  canonical✓  core
+lxd     4.7        18324  latest/stable  canonical✓  -
+
+$ curl --unix-socket /run/snapd.socket 'http://localhost/v2/find?select=refresh'
+{"type":"sync","status-code":200,"status":"OK","result":[{"name":"lxd","version":"4.8","revision":"18546","channel":"stable",...}]}
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cosandr/go-check-updates/api"
+)
+
+const snapdTimeout = 30 * time.Second
+
+// snapdResponse is the common envelope of snapd REST API responses
+type snapdResponse struct {
+	Type       string          `json:"type"`
+	StatusCode int             `json:"status-code"`
+	Result     json.RawMessage `json:"result"`
+}
+
+// snapdSnap is the subset of snap information used here
+type snapdSnap struct {
+	Name            string `json:"name"`
+	Version         string `json:"version"`
+	Revision        string `json:"revision"`
+	Channel         string `json:"channel"`
+	TrackingChannel string `json:"tracking-channel"`
+}
+
+// snapdError is the result of a snapd error response
+type snapdError struct {
+	Message string `json:"message"`
+	Kind    string `json:"kind"`
+}
+
+// snapInstalled is the currently installed version and channel of a snap
+type snapInstalled struct {
+	ver      string
+	tracking string
+}
+
+// snapBackend checks for snap refreshes, using snapd directly if its socket is available
+type snapBackend struct {
+	socket string
+}
+
+func init() {
+	registerBackend(&snapBackend{socket: "/run/snapd.socket"})
+}
+
+func (b *snapBackend) Name() string { return "snap" }
+
+func (b *snapBackend) Detect(ctx context.Context, distro string) bool {
+	return b.hasSocket() || checkCmd("snap")
+}
+
+func (b *snapBackend) Check(ctx context.Context) (api.UpdatesList, error) {
+	if b.hasSocket() {
+		updates, err := b.checkSnapd(ctx)
+		if err == nil {
+			return updates, nil
+		}
+		log.Warnf("snap: snapd API failed, falling back to CLI: %v", err)
+	}
+	return UpdateSnap(ctx)
+}
+
+func (b *snapBackend) LogPaths() []string { return nil }
+
+func (b *snapBackend) ParseLog(ctx context.Context, fp string, f *api.File) error {
+	return fmt.Errorf("snap has no log file")
+}
+
+func (b *snapBackend) Capabilities() Capability { return CapOldVersion | CapRepo }
+
+func (b *snapBackend) hasSocket() bool {
+	if b.socket == "" {
+		return false
+	}
+	_, err := os.Stat(b.socket)
+	return err == nil
+}
+
+// snapdGet sends a GET request to snapd and returns the result of a sync response
+func (b *snapBackend) snapdGet(ctx context.Context, path string) (json.RawMessage, int, error) {
+	client := http.Client{
+		Timeout: snapdTimeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", b.socket)
+			},
+		},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://localhost"+path, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, resp.StatusCode, err
+	}
+	var r snapdResponse
+	if err := json.Unmarshal(body, &r); err != nil {
+		return nil, resp.StatusCode, fmt.Errorf("cannot unmarshal snapd response: %v", err)
+	}
+	if r.Type == "error" {
+		var e snapdError
+		_ = json.Unmarshal(r.Result, &e)
+		return nil, r.StatusCode, fmt.Errorf("snapd error %d: %s", r.StatusCode, e.Message)
+	}
+	return r.Result, r.StatusCode, nil
+}
+
+// checkSnapd queries snapd for installed snaps and available refreshes
+func (b *snapBackend) checkSnapd(ctx context.Context) (api.UpdatesList, error) {
+	raw, _, err := b.snapdGet(ctx, "/v2/snaps")
+	if err != nil {
+		return nil, err
+	}
+	var snaps []snapdSnap
+	if err := json.Unmarshal(raw, &snaps); err != nil {
+		return nil, fmt.Errorf("cannot unmarshal installed snaps: %v", err)
+	}
+	installed := make(map[string]snapInstalled, len(snaps))
+	for _, s := range snaps {
+		installed[s.Name] = snapInstalled{ver: s.Version, tracking: s.TrackingChannel}
+	}
+	raw, code, err := b.snapdGet(ctx, "/v2/find?select=refresh")
+	if err != nil {
+		// No refreshes available
+		if code == http.StatusNotFound {
+			return make(api.UpdatesList, 0), nil
+		}
+		return nil, err
+	}
+	var refresh []snapdSnap
+	if err := json.Unmarshal(raw, &refresh); err != nil {
+		return nil, fmt.Errorf("cannot unmarshal snap refreshes: %v", err)
+	}
+	updates := make(api.UpdatesList, 0, len(refresh))
+	for _, s := range refresh {
+		u := api.Update{
+			Pkg:    s.Name,
+			NewVer: s.Version,
+			Repo:   s.Channel,
+		}
+		if i, ok := installed[s.Name]; ok {
+			u.OldVer = i.ver
+			if i.tracking != "" {
+				u.Repo = i.tracking
+			}
+		}
+		updates = append(updates, u)
+	}
+	return updates, nil
+}
+
+// UpdateSnap uses the snap CLI to get available refreshes
+func UpdateSnap(ctx context.Context) (api.UpdatesList, error) {
+	raw, err := runCmd(ctx, "snap", "list")
+	if err != nil {
+		return api.UpdatesList{}, err
+	}
+	installed := parseSnapList(raw)
+	raw, err = runCmd(ctx, "snap", "refresh", "--list")
+	if err != nil {
+		return api.UpdatesList{}, err
+	}
+	return parseSnapRefreshList(raw, installed), nil
+}
+
+// parseSnapList returns installed snaps from snap list
+func parseSnapList(out string) map[string]snapInstalled {
+	ret := make(map[string]snapInstalled)
+	header := false
+	for _, line := range strings.Split(out, "\n") {
+		cols := strings.Fields(line)
+		if len(cols) > 0 && cols[0] == "Name" {
+			header = true
+			continue
+		}
+		if !header || len(cols) < 4 {
+			continue
+		}
+		ret[cols[0]] = snapInstalled{ver: cols[1], tracking: cols[3]}
+	}
+	return ret
+}
+
+// parseSnapRefreshList returns updates from snap refresh --list
+func parseSnapRefreshList(out string, installed map[string]snapInstalled) api.UpdatesList {
+	updates := make(api.UpdatesList, 0)
+	// Output is "All snaps up to date." if there is nothing to refresh
+	header := false
+	for _, line := range strings.Split(out, "\n") {
+		cols := strings.Fields(line)
+		if len(cols) > 0 && cols[0] == "Name" {
+			header = true
+			continue
+		}
+		if !header || len(cols) < 3 {
+			continue
+		}
+		u := api.Update{
+			Pkg:    cols[0],
+			NewVer: cols[1],
+		}
+		if i, ok := installed[cols[0]]; ok {
+			u.OldVer = i.ver
+			u.Repo = i.tracking
+		}
+		updates = append(updates, u)
+	}
+	return updates
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cosandr/go-check-updates/api"
+)
+
+func checkSnapParse(t *testing.T, expected api.UpdatesList, actual api.UpdatesList) {
+	if len(actual) != len(expected) {
+		t.Fatalf("expected %d updates, got %d: %v", len(expected), len(actual), actual)
+	}
+	for i, a := range actual {
+		if e := expected[i]; !a.Equals(&e) {
+			t.Errorf("expected %v, got %v", e, a)
+		}
+	}
+}
+
+func TestSnapParseRefreshList(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+	list := `Name    Version    Rev    Tracking       Publisher   Notes
+core    16-2.47.1  10185  latest/stable  canonical✓  core
+core18  20201026   1932   latest/stable  canonical✓  base
+lxd     4.7        18324  4.7/stable/…   canonical✓  -
+`
+	refresh := `Name   Version   Rev    Publisher   Notes
+core   16-2.48   10444  canonical✓  core
+lxd    4.8       18546  canonical✓  -
+`
+	expected := api.UpdatesList{
+		{
+			Pkg:    "core",
+			OldVer: "16-2.47.1",
+			NewVer: "16-2.48",
+			Repo:   "latest/stable",
+		},
+		{
+			Pkg:    "lxd",
+			OldVer: "4.7",
+			NewVer: "4.8",
+			Repo:   "4.7/stable/…",
+		},
+	}
+	checkSnapParse(t, expected, parseSnapRefreshList(refresh, parseSnapList(list)))
+	checkSnapParse(t, api.UpdatesList{}, parseSnapRefreshList("All snaps up to date.\n", parseSnapList(list)))
+}
+
+func runSnapdStandIn(t *testing.T, refresh string) (string, func()) {
+	socket := filepath.Join(t.TempDir(), "snapd.socket")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v2/snaps", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"type":"sync","status-code":200,"status":"OK","result":[
+{"id":"99T7MUlRhtI3U0QFgl5mXXESAiSwt776","name":"core","version":"16-2.47.1","revision":"10185","channel":"stable","tracking-channel":"latest/stable"},
+{"id":"J60k4JY0HppjwOjW8dZdYc8obXKxujRu","name":"lxd","version":"4.7","revision":"18324","channel":"4.7/stable","tracking-channel":"4.7/stable"}
+]}`))
+	})
+	mux.HandleFunc("/v2/find", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Query().Get("select") != "refresh" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"type":"error","status-code":400,"status":"Bad Request","result":{"message":"bad select"}}`))
+			return
+		}
+		if refresh == "" {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"type":"error","status-code":404,"status":"Not Found","result":{"message":"snap not found","kind":"snap-not-found"}}`))
+			return
+		}
+		_, _ = w.Write([]byte(refresh))
+	})
+	srv := &http.Server{Handler: mux}
+	go srv.Serve(l)
+	return socket, func() {
+		srv.Close()
+	}
+}
+
+func TestSnapSnapd(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+	socket, stop := runSnapdStandIn(t, `{"type":"sync","status-code":200,"status":"OK","result":[
+{"id":"99T7MUlRhtI3U0QFgl5mXXESAiSwt776","name":"core","version":"16-2.48","revision":"10444","channel":"stable"},
+{"id":"J60k4JY0HppjwOjW8dZdYc8obXKxujRu","name":"lxd","version":"4.8","revision":"18546","channel":"stable"}
+]}`)
+	defer stop()
+	b := &snapBackend{socket: socket}
+	if !b.hasSocket() {
+		t.Fatal("expected socket to be found")
+	}
+	actual, err := b.checkSnapd(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := api.UpdatesList{
+		{
+			Pkg:    "core",
+			OldVer: "16-2.47.1",
+			NewVer: "16-2.48",
+			Repo:   "latest/stable",
+		},
+		{
+			Pkg:    "lxd",
+			OldVer: "4.7",
+			NewVer: "4.8",
+			Repo:   "4.7/stable",
+		},
+	}
+	checkSnapParse(t, expected, actual)
+}
+
+func TestSnapSnapdNoRefresh(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+	socket, stop := runSnapdStandIn(t, "")
+	defer stop()
+	b := &snapBackend{socket: socket}
+	actual, err := b.checkSnapd(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSnapParse(t, api.UpdatesList{}, actual)
+}
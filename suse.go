@@ -0,0 +1,243 @@
+package main
+
+/*
+$ zypper --non-interactive --xmlout list-updates
+<?xml version='1.0'?>
+<stream>
+<message type="info">Loading repository data...</message>
+<message type="info">Reading installed packages...</message>
+<update-status version="0.6">
+<update-list>
+<update kind="package" name="libzypp" edition="17.25.5-1.1" arch="x86_64" edition-old="17.25.4-1.1" ><summary>Library for package, patch, pattern and product management</summary><description></description><license></license><source url="http://download.opensuse.org/tumbleweed/repo/oss" alias="repo-oss"/></update>
+</update-list>
+</update-status>
+</stream>
+
+$ zypper --non-interactive --xmlout list-patches
+<update kind="patch" name="openSUSE-2020-2150" edition="1" arch="noarch" status="needed" category="security" severity="important" ...><source url="..." alias="repo-update"/></update>
+
+$ cat /var/log/zypp/history
+2020-12-05 10:21:33|install|libzypp|17.25.5-1.1|x86_64|root@host|repo-oss|4b2c3...|
+2020-12-05 10:21:35|remove |foo|1.0-1.1|x86_64|root@host|
+2020-12-05 10:21:36|patch  |openSUSE-2020-2150|1|noarch|repo-update|important|security|needed|applied|
+*/
+
+import (
+	"bufio"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cosandr/go-check-updates/api"
+)
+
+const zypperTimeFmt = "2006-01-02 15:04:05"
+
+// zypperStream is the root element of zypper XML output
+type zypperStream struct {
+	Updates []zypperUpdate `xml:"update-status>update-list>update"`
+}
+
+// zypperUpdate is a single package or patch from list-updates or list-patches
+type zypperUpdate struct {
+	Kind       string `xml:"kind,attr"`
+	Name       string `xml:"name,attr"`
+	Edition    string `xml:"edition,attr"`
+	EditionOld string `xml:"edition-old,attr"`
+	Arch       string `xml:"arch,attr"`
+	Status     string `xml:"status,attr"`
+	Category   string `xml:"category,attr"`
+	Severity   string `xml:"severity,attr"`
+	Source     struct {
+		URL   string `xml:"url,attr"`
+		Alias string `xml:"alias,attr"`
+	} `xml:"source"`
+}
+
+// zypperBackend checks for package updates and needed patches with zypper
+type zypperBackend struct {
+	logFp string
+}
+
+func init() {
+	registerBackend(&zypperBackend{logFp: "/var/log/zypp/history"})
+}
+
+func (b *zypperBackend) Name() string { return "zypper" }
+
+func (b *zypperBackend) Detect(ctx context.Context, distro string) bool {
+	switch distro {
+	case "opensuse-tumbleweed", "opensuse-leap", "sles", "sled":
+		return true
+	}
+	return false
+}
+
+func (b *zypperBackend) Check(ctx context.Context) (api.UpdatesList, error) { return UpdateZypper(ctx) }
+
+func (b *zypperBackend) LogPaths() []string {
+	if b.logFp == "" {
+		return nil
+	}
+	return []string{b.logFp}
+}
+
+func (b *zypperBackend) ParseLog(ctx context.Context, fp string, f *api.File) error {
+	return checkZypperLogs(fp, f)
+}
+
+func (b *zypperBackend) Capabilities() Capability { return CapOldVersion | CapRepo | CapLogs }
+
+func runZypper(ctx context.Context, cmd string) (string, error) {
+	raw, err := runCmd(ctx, "zypper", "--non-interactive", "--xmlout", cmd)
+	if err != nil {
+		// 100-103 are informational, e.g. 100 means patches are needed
+		if exitError, ok := err.(*exec.ExitError); ok && exitError.ExitCode() >= 100 && exitError.ExitCode() <= 103 {
+			err = nil
+		}
+	}
+	return raw, err
+}
+
+// UpdateZypper uses zypper to get available package updates and needed patches
+func UpdateZypper(ctx context.Context) (api.UpdatesList, error) {
+	raw, err := runZypper(ctx, "list-updates")
+	if err != nil {
+		return api.UpdatesList{}, err
+	}
+	updates, err := parseZypperXML(raw)
+	if err != nil {
+		return updates, err
+	}
+	raw, err = runZypper(ctx, "list-patches")
+	if err != nil {
+		return updates, fmt.Errorf("list-patches failed: %v", err)
+	}
+	patches, err := parseZypperXML(raw)
+	if err != nil {
+		return updates, err
+	}
+	return append(updates, patches...), nil
+}
+
+func parseZypperXML(out string) (api.UpdatesList, error) {
+	updates := make(api.UpdatesList, 0)
+	var stream zypperStream
+	if err := xml.Unmarshal([]byte(out), &stream); err != nil {
+		return updates, fmt.Errorf("cannot parse zypper output: %v", err)
+	}
+	for _, u := range stream.Updates {
+		var kind string
+		switch u.Kind {
+		case "package":
+		case "patch":
+			// Only needed patches are listed by default, but check anyway
+			if u.Status != "" && u.Status != "needed" {
+				continue
+			}
+			// Patches update packages which are listed already, keep them apart
+			kind = api.KindPatch
+		default:
+			continue
+		}
+		updates = append(updates, api.Update{
+			Pkg:      u.Name,
+			OldVer:   u.EditionOld,
+			NewVer:   u.Edition,
+			Repo:     u.Source.Alias,
+			Arch:     u.Arch,
+			Category: u.Category,
+			Severity: u.Severity,
+			Kind:     kind,
+		})
+	}
+	return updates, nil
+}
+
+// zypperArch returns the arch of a history line split on |, empty if missing
+func zypperArch(fields []string) string {
+	if len(fields) < 5 {
+		return ""
+	}
+	return strings.TrimSpace(fields[4])
+}
+
+// removeZypper removes packages or patches like api.File.RemoveArch, leaving the other kind alone
+func removeZypper(f *api.File, name string, arch string, newVer string, patch bool) bool {
+	updates := make(api.UpdatesList, 0, len(f.Updates))
+	for _, u := range f.Updates {
+		if (u.Kind == api.KindPatch) != patch || u.Pkg != name || (newVer != "" && u.NewVer != newVer) ||
+			(arch != "" && u.Arch != "" && u.Arch != arch) {
+			updates = append(updates, u)
+		}
+	}
+	changed := len(f.Updates) != len(updates)
+	f.Updates = updates
+	return changed
+}
+
+// checkZypperLogs read zypp history file and update f accordingly
+func checkZypperLogs(fp string, f *api.File) error {
+	file, err := os.Open(fp)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	lastChecked, err := time.Parse(time.RFC3339, f.Checked)
+	if err != nil {
+		return fmt.Errorf("cannot parse cached time '%s': %v", f.Checked, err)
+	}
+	beforeLen := len(f.Updates)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, "|")
+		if len(fields) < 4 {
+			continue
+		}
+		timestamp, action, name, ver := fields[0], strings.TrimSpace(fields[1]), fields[2], fields[3]
+		t, err := time.ParseInLocation(zypperTimeFmt, timestamp, time.Local)
+		if err != nil {
+			log.Debugf("checkZypperLogs: cannot parse '%s': %v", timestamp, err)
+			continue
+		}
+		if t.Before(lastChecked) {
+			log.Debugf("checkZypperLogs: skip '%s', timestamp too early %v", name, t)
+			continue
+		}
+		switch action {
+		case "install":
+			if changed := removeZypper(f, name, zypperArch(fields), ver, false); changed {
+				log.Debugf("checkZypperLogs: removed upgraded package %s %s", name, ver)
+			} else {
+				log.Debugf("checkZypperLogs: skip installed package %s %s", name, ver)
+			}
+		case "remove":
+			if changed := removeZypper(f, name, zypperArch(fields), "", false); changed {
+				log.Debugf("checkZypperLogs: removed uninstalled package %s %s", name, ver)
+			} else {
+				log.Debugf("checkZypperLogs: skip uninstalled package %s %s", name, ver)
+			}
+		case "patch":
+			// <arch>|<repo>|<severity>|<category>|<old state>|<new state>
+			if containsString(fields[4:], "applied") {
+				if changed := removeZypper(f, name, "", ver, true); changed {
+					log.Debugf("checkZypperLogs: removed applied patch %s", name)
+				}
+			}
+		}
+	}
+	if len(f.Updates) != beforeLen {
+		log.Infof("checkZypperLogs: %s: removed %d pending updates", fp, beforeLen-len(f.Updates))
+	}
+	return scanner.Err()
+}
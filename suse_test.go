@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cosandr/go-check-updates/api"
+)
+
+func checkSuseParse(t *testing.T, expected api.UpdatesList, actual api.UpdatesList) {
+	if len(actual) != len(expected) {
+		t.Fatalf("expected %d updates, got %d", len(expected), len(actual))
+	}
+	for i, a := range actual {
+		e := expected[i]
+		if !a.Equals(&e) {
+			t.Errorf("expected %v, got %v", e, a)
+		}
+		if a.Category != e.Category {
+			t.Errorf("expected category %s, got %s", e.Category, a.Category)
+		}
+		if a.Severity != e.Severity {
+			t.Errorf("expected severity %s, got %s", e.Severity, a.Severity)
+		}
+	}
+}
+
+func TestSuseParseListUpdates(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+	out := `<?xml version='1.0'?>
+<stream>
+<message type="info">Loading repository data...</message>
+<message type="info">Reading installed packages...</message>
+<update-status version="0.6">
+<update-list>
+<update kind="package" name="libzypp" edition="17.25.5-1.1" arch="x86_64" edition-old="17.25.4-1.1" ><summary>Library for package, patch, pattern and product management</summary><description>libzypp is the package management library.</description><license></license><source url="http://download.opensuse.org/tumbleweed/repo/oss" alias="repo-oss"/></update>
+<update kind="package" name="MozillaFirefox" edition="83.0-1.1" arch="x86_64" edition-old="82.0.3-1.1" ><summary>Mozilla Firefox Web Browser</summary><description>Mozilla Firefox is a standalone web browser.</description><license></license><source url="http://download.opensuse.org/tumbleweed/repo/oss" alias="repo-oss"/></update>
+<update kind="package" name="vlc-codecs" edition="3.0.11.1-7.9" arch="x86_64" edition-old="3.0.11.1-7.7" ><summary>Additional codecs for the VLC media player</summary><description></description><license></license><source url="https://ftp.gwdg.de/pub/linux/misc/packman/suse/openSUSE_Tumbleweed/" alias="packman"/></update>
+</update-list>
+</update-status>
+</stream>
+`
+	actual, err := parseZypperXML(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := api.UpdatesList{
+		{
+			Pkg:    "libzypp",
+			OldVer: "17.25.4-1.1",
+			NewVer: "17.25.5-1.1",
+			Repo:   "repo-oss",
+			Arch:   "x86_64",
+		},
+		{
+			Pkg:    "MozillaFirefox",
+			OldVer: "82.0.3-1.1",
+			NewVer: "83.0-1.1",
+			Repo:   "repo-oss",
+			Arch:   "x86_64",
+		},
+		{
+			Pkg:    "vlc-codecs",
+			OldVer: "3.0.11.1-7.7",
+			NewVer: "3.0.11.1-7.9",
+			Repo:   "packman",
+			Arch:   "x86_64",
+		},
+	}
+	checkSuseParse(t, expected, actual)
+	// No updates
+	out = `<?xml version='1.0'?>
+<stream>
+<message type="info">Loading repository data...</message>
+<message type="info">Reading installed packages...</message>
+<update-status version="0.6">
+<update-list>
+</update-list>
+</update-status>
+</stream>
+`
+	actual, err = parseZypperXML(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSuseParse(t, api.UpdatesList{}, actual)
+	// Garbage
+	if _, err = parseZypperXML("Repository 'repo-oss' is invalid."); err == nil {
+		t.Error("expected error for invalid XML")
+	}
+}
+
+func TestSuseParseListPatches(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+	out := `<?xml version='1.0'?>
+<stream>
+<message type="info">Loading repository data...</message>
+<message type="info">Reading installed packages...</message>
+<update-status version="0.6">
+<update-list>
+<update kind="patch" name="openSUSE-2020-2150" edition="1" arch="noarch" status="needed" category="security" severity="important" pkgmanager="false" restart="false" interactive="false"><summary>Security update for xen</summary><description>This update for xen fixes the following issues.</description><license/><source url="http://download.opensuse.org/update/leap/15.2/oss" alias="repo-update"/><issue-date time="1607262043"/><issue-list><issue type="cve" id="CVE-2020-29040"/></issue-list></update>
+<update kind="patch" name="openSUSE-2020-2141" edition="1" arch="noarch" status="needed" category="recommended" severity="moderate" pkgmanager="false" restart="false" interactive="false"><summary>Recommended update for dracut</summary><description>This update for dracut fixes the following issues.</description><license/><source url="http://download.opensuse.org/update/leap/15.2/oss" alias="repo-update"/><issue-date time="1607103616"/></update>
+<update kind="patch" name="openSUSE-2020-1999" edition="1" arch="noarch" status="applied" category="optional" severity="low" pkgmanager="false" restart="false" interactive="false"><summary>Optional update for foo</summary><description></description><license/><source url="http://download.opensuse.org/update/leap/15.2/oss" alias="repo-update"/></update>
+</update-list>
+</update-status>
+</stream>
+`
+	actual, err := parseZypperXML(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := api.UpdatesList{
+		{
+			Pkg:      "openSUSE-2020-2150",
+			NewVer:   "1",
+			Repo:     "repo-update",
+			Category: "security",
+			Severity: "important",
+			Arch:     "noarch",
+			Kind:     api.KindPatch,
+		},
+		{
+			Pkg:      "openSUSE-2020-2141",
+			NewVer:   "1",
+			Repo:     "repo-update",
+			Category: "recommended",
+			Severity: "moderate",
+			Arch:     "noarch",
+			Kind:     api.KindPatch,
+		},
+	}
+	checkSuseParse(t, expected, actual)
+}
+
+func TestSuseZypperLogs(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+	cache = NewInternalCache()
+	cache.f.Checked = time.Date(2020, 12, 5, 10, 0, 0, 0, time.Local).Format(time.RFC3339)
+	allUpdates := api.UpdatesList{
+		{
+			Pkg:    "libzypp",
+			NewVer: "17.25.5-1.1",
+		},
+		{
+			Pkg:    "MozillaFirefox",
+			NewVer: "83.0-1.1",
+		},
+		{
+			Pkg:    "vlc-codecs",
+			NewVer: "3.0.11.1-7.9",
+		},
+		{
+			Pkg:      "openSUSE-2020-2150",
+			NewVer:   "1",
+			Category: "security",
+			Kind:     api.KindPatch,
+		},
+	}
+	file := `
+# 2020-12-04 18:00:01 libzypp-17.25.4-1.1.x86_64.rpm installed ok
+2020-12-04 18:00:01|install|libzypp|17.25.4-1.1|x86_64|root@host|repo-oss|4b2c3f7b0b2d3a0e|
+# 2020-12-05 10:21:33 libzypp-17.25.5-1.1.x86_64.rpm installed ok
+2020-12-05 10:21:33|install|libzypp|17.25.5-1.1|x86_64|root@host|repo-oss|5c1e1cda0f3b4d2a|
+2020-12-05 10:21:34|install|MozillaFirefox|83.0|x86_64|root@host|repo-oss|6c2e1cda0f3b4d2b|
+2020-12-05 10:21:35|remove |vlc-codecs|3.0.11.1-7.7|x86_64|root@host|
+2020-12-05 10:21:36|patch  |openSUSE-2020-2150|1|noarch|repo-update|important|security|needed|applied|
+`
+	cache.f.Updates = allUpdates.Copy()
+	fp := "/tmp/zypper_test.log"
+	if err := ioutil.WriteFile(fp, []byte(file), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkZypperLogs(fp, &cache.f); err != nil {
+		t.Fatal(err)
+	}
+	// Firefox was installed with the wrong version
+	if len(cache.f.Updates) != 1 || cache.f.Updates[0].Pkg != "MozillaFirefox" {
+		t.Errorf("Expected only MozillaFirefox, got %d: %v", len(cache.f.Updates), cache.f.Updates)
+	}
+}
+
+func TestRunZypperExitCodes(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+	dir := t.TempDir()
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", dir)
+	for code, ok := range map[int]bool{0: true, 1: false, 100: true, 103: true, 104: false, 106: false, 107: false} {
+		script := fmt.Sprintf("#!/bin/sh\nexit %d\n", code)
+		if err := ioutil.WriteFile(filepath.Join(dir, "zypper"), []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+		_, err := runZypper(context.Background(), "list-updates")
+		if ok && err != nil {
+			t.Errorf("exit code %d: unexpected error %v", code, err)
+		} else if !ok && err == nil {
+			t.Errorf("exit code %d: expected error", code)
+		}
+	}
+}
@@ -3,11 +3,17 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"net/http"
 	"os"
 	"os/exec"
 	"path"
 	"regexp"
+	"sort"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/sys/unix"
@@ -89,13 +95,94 @@ func checkFileExists(path string) bool {
 	return !info.IsDir()
 }
 
-func runCmd(name string, args ...string) (string, error) {
+// runCmd runs a command and returns its standard output
+//
+// The command runs in its own process group, if ctx is done before it exits
+// the whole group is killed so that child processes don't linger, and ctx.Err() is returned.
+func runCmd(ctx context.Context, name string, args ...string) (string, error) {
 	log.Debugf("runCmd %s %s", name, args)
 	var buf bytes.Buffer
 	cmd := exec.Command(name, args...)
 	cmd.Stdout = &buf
-	err := cmd.Run()
-	return buf.String(), err
+	cmd.SysProcAttr = &unix.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		return "", err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	select {
+	case err := <-done:
+		return buf.String(), err
+	case <-ctx.Done():
+		log.Debugf("runCmd %s: %v, killing process group %d", name, ctx.Err(), cmd.Process.Pid)
+		// Negative PID signals every process in the group
+		if err := unix.Kill(-cmd.Process.Pid, unix.SIGKILL); err != nil {
+			log.Warnf("runCmd %s: cannot kill process group: %v", name, err)
+		}
+		<-done
+		return buf.String(), ctx.Err()
+	}
+}
+
+// httpGetJSON sends a GET request to url and unmarshals the JSON response into v
+func httpGetJSON(ctx context.Context, url string, v interface{}) error {
+	client := http.Client{Timeout: 30 * time.Second}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", packageName)
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: unexpected status %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// writeFileAtomic writes data to a temporary file next to fp, syncs it and renames it to fp
+//
+// Readers see either the previous or the new content, never a partially written file.
+// The directory of fp must be writable.
+func writeFileAtomic(fp string, data []byte, perm os.FileMode) error {
+	dir := path.Dir(fp)
+	tmp, err := ioutil.TempFile(dir, "."+path.Base(fp)+".*")
+	if err != nil {
+		return err
+	}
+	// Remove the temporary file unless it was renamed
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), fp); err != nil {
+		return err
+	}
+	// Persist the rename as well
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
 }
 
 // checkCmd returns true if '<name> --help' ran successfully
@@ -104,3 +191,13 @@ func checkCmd(name string) bool {
 	err := cmd.Run()
 	return err == nil
 }
+
+// sortedKeys returns the keys of m in ascending order
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
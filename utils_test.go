@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestRunCmdKillsProcessGroup(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	// The shell prints the PID of its child and waits for it, like checkupdates does with pacman
+	out, err := runCmd(ctx, "sh", "-c", "sleep 30 & echo $!; wait")
+	if err != context.DeadlineExceeded {
+		t.Errorf("Expected deadline exceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected command to be killed, took %v", elapsed)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(out))
+	if err != nil {
+		t.Fatalf("cannot parse child PID from '%s': %v", out, err)
+	}
+	// The orphaned child may need a moment to die, it stays a zombie until reaped by init
+	for i := 0; i < 50; i++ {
+		raw, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+		if err != nil {
+			return
+		}
+		// State follows the command name in parentheses
+		if fields := strings.Fields(string(raw[strings.LastIndex(string(raw), ")")+1:])); len(fields) > 0 && fields[0] == "Z" {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Errorf("Expected child process %d to be killed", pid)
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+	tmp, err := ioutil.TempDir("", "test_write")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	fp := filepath.Join(tmp, "cache.json")
+	for _, content := range []string{`{"checked":"first"}`, `{}`} {
+		if err := writeFileAtomic(fp, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		raw, err := ioutil.ReadFile(fp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(raw) != content {
+			t.Errorf("Expected '%s', got '%s'", content, raw)
+		}
+	}
+	info, err := os.Stat(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0644 {
+		t.Errorf("Expected mode 0644, got %v", info.Mode().Perm())
+	}
+	// No temporary files are left behind
+	entries, err := ioutil.ReadDir(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("Expected only the written file, got %d entries", len(entries))
+	}
+	// Never written in place
+	if os.Geteuid() == 0 {
+		return
+	}
+	if err := os.Chmod(tmp, 0555); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(tmp, 0755)
+	if err := writeFileAtomic(fp, []byte(`{"checked":"second"}`), 0644); err == nil {
+		t.Error("Expected error if the directory isn't writable")
+	}
+	if raw, _ := ioutil.ReadFile(fp); string(raw) != `{}` {
+		t.Errorf("Expected file to be unchanged, got '%s'", raw)
+	}
+}
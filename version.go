@@ -0,0 +1,641 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/cosandr/go-check-updates/api"
+)
+
+// isDigit returns true if c is an ASCII digit
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// isNumeric returns true if s is not empty and only has ASCII digits
+func isNumeric(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if !isDigit(s[i]) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// isAlpha returns true if c is an ASCII letter
+func isAlpha(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// alpmSegmentCmp compares version strings the way libalpm's rpmvercmp does
+//
+// Returns -1 if a is older than b, 0 if they are equal and 1 if a is newer.
+func alpmSegmentCmp(a, b string) int {
+	if a == b {
+		return 0
+	}
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		// Skip separators
+		si, sj := i, j
+		for i < len(a) && !isDigit(a[i]) && !isAlpha(a[i]) {
+			i++
+		}
+		for j < len(b) && !isDigit(b[j]) && !isAlpha(b[j]) {
+			j++
+		}
+		if i >= len(a) || j >= len(b) {
+			break
+		}
+		// More separators is newer
+		if i-si != j-sj {
+			if i-si < j-sj {
+				return -1
+			}
+			return 1
+		}
+		// Grab the next segment of the same type from both
+		isNum := isDigit(a[i])
+		same := isAlpha
+		if isNum {
+			same = isDigit
+		}
+		ei, ej := i, j
+		for ei < len(a) && same(a[ei]) {
+			ei++
+		}
+		for ej < len(b) && same(b[ej]) {
+			ej++
+		}
+		// Segments of different types, numeric is newer
+		if ej == j {
+			if isNum {
+				return 1
+			}
+			return -1
+		}
+		segA, segB := a[i:ei], b[j:ej]
+		if isNum {
+			segA = strings.TrimLeft(segA, "0")
+			segB = strings.TrimLeft(segB, "0")
+			// Longer number is larger
+			if len(segA) != len(segB) {
+				if len(segA) < len(segB) {
+					return -1
+				}
+				return 1
+			}
+		}
+		if c := strings.Compare(segA, segB); c != 0 {
+			return c
+		}
+		i, j = ei, ej
+	}
+	if i >= len(a) && j >= len(b) {
+		return 0
+	}
+	// A remaining alpha segment never beats an empty string
+	if (i >= len(a) && !isAlpha(b[j])) || (i < len(a) && isAlpha(a[i])) {
+		return -1
+	}
+	return 1
+}
+
+// splitAlpmEVR splits [<epoch>:]<version>[-<release>], epoch defaults to 0
+func splitAlpmEVR(evr string) (epoch, ver, rel string) {
+	epoch = "0"
+	if i := strings.Index(evr, ":"); i >= 0 {
+		if i > 0 {
+			epoch = evr[:i]
+		}
+		evr = evr[i+1:]
+	}
+	ver = evr
+	if i := strings.LastIndex(evr, "-"); i >= 0 {
+		ver, rel = evr[:i], evr[i+1:]
+	}
+	return
+}
+
+// vercmpAlpm compares pacman package versions like vercmp(8)
+//
+// Returns -1 if a is older than b, 0 if they are equal and 1 if a is newer.
+// The release is only compared if both versions have one.
+func vercmpAlpm(a, b string) int {
+	if a == b {
+		return 0
+	}
+	epochA, verA, relA := splitAlpmEVR(a)
+	epochB, verB, relB := splitAlpmEVR(b)
+	if c := alpmSegmentCmp(epochA, epochB); c != 0 {
+		return c
+	}
+	if c := alpmSegmentCmp(verA, verB); c != 0 {
+		return c
+	}
+	if relA != "" && relB != "" {
+		return alpmSegmentCmp(relA, relB)
+	}
+	return 0
+}
+
+// rpmSegmentCmp compares version strings like rpmvercmp from rpm 4.15+
+//
+// Separators are ignored, ~ sorts before anything and ^ sorts after the end of the string but before anything else.
+// Returns -1 if a is older than b, 0 if they are equal and 1 if a is newer.
+func rpmSegmentCmp(a, b string) int {
+	if a == b {
+		return 0
+	}
+	isSep := func(c byte) bool { return !isDigit(c) && !isAlpha(c) && c != '~' && c != '^' }
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		for i < len(a) && isSep(a[i]) {
+			i++
+		}
+		for j < len(b) && isSep(b[j]) {
+			j++
+		}
+		// Tilde sorts before everything else
+		if (i < len(a) && a[i] == '~') || (j < len(b) && b[j] == '~') {
+			if i >= len(a) || a[i] != '~' {
+				return 1
+			}
+			if j >= len(b) || b[j] != '~' {
+				return -1
+			}
+			i++
+			j++
+			continue
+		}
+		// Caret sorts after the end of the string, but before anything else
+		if (i < len(a) && a[i] == '^') || (j < len(b) && b[j] == '^') {
+			if i >= len(a) {
+				return -1
+			}
+			if j >= len(b) {
+				return 1
+			}
+			if a[i] != '^' {
+				return 1
+			}
+			if b[j] != '^' {
+				return -1
+			}
+			i++
+			j++
+			continue
+		}
+		if i >= len(a) || j >= len(b) {
+			break
+		}
+		isNum := isDigit(a[i])
+		same := isAlpha
+		if isNum {
+			same = isDigit
+		}
+		ei, ej := i, j
+		for ei < len(a) && same(a[ei]) {
+			ei++
+		}
+		for ej < len(b) && same(b[ej]) {
+			ej++
+		}
+		// Segments of different types, numeric is newer
+		if ej == j {
+			if isNum {
+				return 1
+			}
+			return -1
+		}
+		segA, segB := a[i:ei], b[j:ej]
+		if isNum {
+			segA = strings.TrimLeft(segA, "0")
+			segB = strings.TrimLeft(segB, "0")
+			if len(segA) != len(segB) {
+				if len(segA) < len(segB) {
+					return -1
+				}
+				return 1
+			}
+		}
+		if c := strings.Compare(segA, segB); c != 0 {
+			return c
+		}
+		i, j = ei, ej
+	}
+	if i >= len(a) && j >= len(b) {
+		return 0
+	}
+	if i >= len(a) {
+		return -1
+	}
+	return 1
+}
+
+// vercmpRpm compares RPM [<epoch>:]<version>[-<release>] strings like rpmdev-vercmp
+//
+// Returns -1 if a is older than b, 0 if they are equal and 1 if a is newer.
+// The release is only compared if both versions have one.
+func vercmpRpm(a, b string) int {
+	if a == b {
+		return 0
+	}
+	epochA, verA, relA := splitAlpmEVR(a)
+	epochB, verB, relB := splitAlpmEVR(b)
+	if c := rpmSegmentCmp(epochA, epochB); c != 0 {
+		return c
+	}
+	if c := rpmSegmentCmp(verA, verB); c != 0 {
+		return c
+	}
+	if relA != "" && relB != "" {
+		return rpmSegmentCmp(relA, relB)
+	}
+	return 0
+}
+
+// debOrder returns the sort weight of a non-digit character in a Debian version
+func debOrder(s string, i int) int {
+	if i >= len(s) {
+		return 0
+	}
+	c := s[i]
+	switch {
+	case isDigit(c):
+		return 0
+	case isAlpha(c):
+		return int(c)
+	case c == '~':
+		return -1
+	}
+	return int(c) + 256
+}
+
+// debSegmentCmp compares Debian upstream versions or revisions like dpkg's verrevcmp
+func debSegmentCmp(a, b string) int {
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		// Non-digit prefix, letters sort before non-letters and ~ before everything
+		for (i < len(a) && !isDigit(a[i])) || (j < len(b) && !isDigit(b[j])) {
+			ac, bc := debOrder(a, i), debOrder(b, j)
+			if ac != bc {
+				if ac < bc {
+					return -1
+				}
+				return 1
+			}
+			i++
+			j++
+		}
+		for i < len(a) && a[i] == '0' {
+			i++
+		}
+		for j < len(b) && b[j] == '0' {
+			j++
+		}
+		firstDiff := 0
+		for i < len(a) && isDigit(a[i]) && j < len(b) && isDigit(b[j]) {
+			if firstDiff == 0 {
+				firstDiff = int(a[i]) - int(b[j])
+			}
+			i++
+			j++
+		}
+		if i < len(a) && isDigit(a[i]) {
+			return 1
+		}
+		if j < len(b) && isDigit(b[j]) {
+			return -1
+		}
+		if firstDiff < 0 {
+			return -1
+		} else if firstDiff > 0 {
+			return 1
+		}
+	}
+	return 0
+}
+
+// splitDebVersion splits [<epoch>:]<upstream>[-<revision>], epoch defaults to 0
+func splitDebVersion(v string) (epoch int, upstream, revision string) {
+	if i := strings.Index(v, ":"); i >= 0 {
+		epoch, _ = strconv.Atoi(v[:i])
+		v = v[i+1:]
+	}
+	upstream = v
+	if i := strings.LastIndex(v, "-"); i >= 0 {
+		upstream, revision = v[:i], v[i+1:]
+	}
+	return
+}
+
+// vercmpDeb compares Debian package versions like dpkg --compare-versions
+//
+// Returns -1 if a is older than b, 0 if they are equal and 1 if a is newer.
+func vercmpDeb(a, b string) int {
+	if a == b {
+		return 0
+	}
+	epochA, upA, revA := splitDebVersion(a)
+	epochB, upB, revB := splitDebVersion(b)
+	if epochA != epochB {
+		if epochA < epochB {
+			return -1
+		}
+		return 1
+	}
+	if c := debSegmentCmp(upA, upB); c != 0 {
+		return c
+	}
+	return debSegmentCmp(revA, revB)
+}
+
+// numericCmp compares two strings of ASCII digits by value
+func numericCmp(a, b string) int {
+	a, b = strings.TrimLeft(a, "0"), strings.TrimLeft(b, "0")
+	if len(a) != len(b) {
+		if len(a) < len(b) {
+			return -1
+		}
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+// semverIdentCmp compares dot separated identifiers, numeric ones are compared by value and sort before others
+//
+// A prefix sorts before the longer list, e.g. 1.0 < 1.0.0 and alpha < alpha.1
+func semverIdentCmp(a, b string) int {
+	identsA, identsB := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(identsA) && i < len(identsB); i++ {
+		x, y := identsA[i], identsB[i]
+		var c int
+		switch {
+		case isNumeric(x) && isNumeric(y):
+			c = numericCmp(x, y)
+		case isNumeric(x):
+			c = -1
+		case isNumeric(y):
+			c = 1
+		default:
+			c = strings.Compare(x, y)
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	if len(identsA) != len(identsB) {
+		if len(identsA) < len(identsB) {
+			return -1
+		}
+		return 1
+	}
+	return 0
+}
+
+// vercmpSemver compares semantic versions, e.g. 1.0.0-rc.1 < 1.0.0, a leading v is allowed
+//
+// Returns -1 if a is older than b, 0 if they are equal and 1 if a is newer.
+// Build metadata is ignored, a version without a prerelease is newer than one with the same core.
+func vercmpSemver(a, b string) int {
+	split := func(v string) (core, pre string) {
+		v = strings.TrimPrefix(v, "v")
+		if i := strings.Index(v, "+"); i >= 0 {
+			v = v[:i]
+		}
+		if i := strings.Index(v, "-"); i >= 0 {
+			return v[:i], v[i+1:]
+		}
+		return v, ""
+	}
+	coreA, preA := split(a)
+	coreB, preB := split(b)
+	if c := semverIdentCmp(coreA, coreB); c != 0 {
+		return c
+	}
+	switch {
+	case preA == preB:
+		return 0
+	case preA == "":
+		return 1
+	case preB == "":
+		return -1
+	}
+	return semverIdentCmp(preA, preB)
+}
+
+// Group 1: epoch (optional)
+// Group 2: release, e.g. 1.0.2
+// Group 3: pre-release phase (optional)
+// Group 4: pre-release number (optional)
+// Group 5: post-release number, implicit form 1.0-1 (optional)
+// Group 6: post-release marker (optional)
+// Group 7: post-release number (optional)
+// Group 8: dev-release marker (optional)
+// Group 9: dev-release number (optional)
+var rePep440 = regexp.MustCompile(`^v?(?:(\d+)!)?(\d+(?:\.\d+)*)` +
+	`(?:[-_.]?(a|alpha|b|beta|c|rc|pre|preview)[-_.]?(\d*))?` +
+	`(?:-(\d+)|[-_.]?(post|rev|r)[-_.]?(\d*))?` +
+	`(?:[-_.]?(dev)[-_.]?(\d*))?(?:\+[a-z0-9.]*)?$`)
+
+// pep440Phases orders the pre-release phases, missing ones sort after all of them
+var pep440Phases = map[string]int{"a": 1, "alpha": 1, "b": 2, "beta": 2, "c": 3, "rc": 3, "pre": 3, "preview": 3}
+
+// pep440Key returns the release and the other parts of a PEP 440 version in comparison order, false if it isn't one
+//
+// The release has trailing zeros removed, the epoch, pre, post and dev releases are
+// turned into numbers so that e.g. 1.0.dev0 < 1.0a1 < 1.0 < 1.0.post1.
+func pep440Key(v string) (release string, rest []int64, ok bool) {
+	m := rePep440.FindStringSubmatch(strings.ToLower(strings.TrimSpace(v)))
+	if m == nil {
+		return "", nil, false
+	}
+	num := func(s string) int64 {
+		n, _ := strconv.ParseInt(s, 10, 64)
+		return n
+	}
+	release = m[2]
+	for strings.HasSuffix(release, ".0") {
+		release = strings.TrimSuffix(release, ".0")
+	}
+	// Phase, dev-only releases sort before pre-releases and final ones after them
+	phase, preNum := int64(4), int64(0)
+	if m[3] != "" {
+		phase, preNum = int64(pep440Phases[m[3]]), num(m[4])
+	} else if m[8] != "" && m[5] == "" && m[6] == "" {
+		phase = 0
+	}
+	// Post-release, missing ones sort first
+	hasPost, postNum := int64(0), int64(0)
+	if m[5] != "" {
+		hasPost, postNum = 1, num(m[5])
+	} else if m[6] != "" {
+		hasPost, postNum = 1, num(m[7])
+	}
+	// Dev-release, missing ones sort last
+	noDev, devNum := int64(1), int64(0)
+	if m[8] != "" {
+		noDev, devNum = 0, num(m[9])
+	}
+	return release, []int64{num(m[1]), phase, preNum, hasPost, postNum, noDev, devNum}, true
+}
+
+// vercmpPep440 compares Python package versions according to PEP 440
+//
+// Returns -1 if a is older than b, 0 if they are equal and 1 if a is newer.
+// Versions which aren't valid PEP 440 are compared like vercmp.
+func vercmpPep440(a, b string) int {
+	relA, restA, okA := pep440Key(a)
+	relB, restB, okB := pep440Key(b)
+	if !okA || !okB {
+		return vercmpAlpm(a, b)
+	}
+	// Epoch first
+	if restA[0] != restB[0] {
+		if restA[0] < restB[0] {
+			return -1
+		}
+		return 1
+	}
+	if c := semverIdentCmp(relA, relB); c != 0 {
+		return c
+	}
+	for i := 1; i < len(restA); i++ {
+		if restA[i] != restB[i] {
+			if restA[i] < restB[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// versionScheme describes how the versions of a backend are ordered and where their release starts
+type versionScheme struct {
+	cmp func(a, b string) int
+	// Separator between version and release, the last one is used
+	relSep string
+}
+
+var (
+	schemeAlpm = versionScheme{cmp: vercmpAlpm, relSep: "-"}
+	schemeRpm  = versionScheme{cmp: vercmpRpm, relSep: "-"}
+	schemeDeb  = versionScheme{cmp: vercmpDeb, relSep: "-"}
+)
+
+// changeLevels lists valid change levels, from largest to smallest
+var changeLevels = []string{api.ChangeMajor, api.ChangeMinor, api.ChangePatch, api.ChangeRelease}
+
+// versionSchemes maps backend names to their version scheme
+//
+// Updates of backends which are not listed are not classified, their versions
+// follow other rules (e.g. semver, PEP 440, Gentoo) or cannot be ordered at all.
+var versionSchemes = map[string]*versionScheme{
+	"apt":    &schemeDeb,
+	"dnf":    &schemeRpm,
+	"pacman": &schemeAlpm,
+	"zypper": &schemeRpm,
+}
+
+// splitVersion splits [<epoch>:]<version>[<relSep><release>] and returns the version's leading numeric components
+//
+// Components are taken until the first one which isn't numeric, it is the last one if it starts with a digit.
+// e.g. 1:5.10.6-1 -> 1, 5.10.6, [5 10 6], 1 and 1.1.1h.fc33 -> 0, 1.1.1h.fc33, [1 1 1h], empty.
+// Debian native versions like 11ubuntu5.1 have no numeric components.
+func (s *versionScheme) splitVersion(v string) (epoch string, ver string, components []string, rel string) {
+	epoch = "0"
+	if i := strings.Index(v, ":"); i >= 0 {
+		epoch = strings.TrimLeft(v[:i], "0")
+		v = v[i+1:]
+	}
+	if i := strings.LastIndex(v, s.relSep); i >= 0 {
+		v, rel = v[:i], v[i+len(s.relSep):]
+	}
+	ver = v
+	fields := strings.FieldsFunc(v, func(c rune) bool {
+		return c > 127 || !isDigit(byte(c)) && !isAlpha(byte(c))
+	})
+	for _, f := range fields {
+		if isNumeric(f) {
+			if trimmed := strings.TrimLeft(f, "0"); trimmed != "" {
+				f = trimmed
+			}
+			components = append(components, f)
+			continue
+		}
+		// e.g. 1h in 1.1.1h, but not a version starting with letters
+		if len(components) > 0 && isDigit(f[0]) {
+			components = append(components, f)
+		}
+		break
+	}
+	return
+}
+
+// changeLevel returns how much changed from oldVer to newVer and true if newVer is older
+//
+// The level is api.ChangeMajor if the epoch or first version component changed, api.ChangeMinor for the second,
+// api.ChangePatch for any later one and api.ChangeRelease if only the release changed.
+// It is empty if the versions are equal or the change cannot be told from their numeric components.
+func (s *versionScheme) changeLevel(oldVer, newVer string) (level string, downgrade bool) {
+	c := s.cmp(oldVer, newVer)
+	if c == 0 {
+		return "", false
+	}
+	downgrade = c > 0
+	oldEpoch, oldV, oldComp, oldRel := s.splitVersion(oldVer)
+	newEpoch, newV, newComp, newRel := s.splitVersion(newVer)
+	if oldEpoch != newEpoch {
+		return api.ChangeMajor, downgrade
+	}
+	if len(oldComp) == 0 || len(newComp) == 0 {
+		return "", downgrade
+	}
+	for i := 0; i < len(oldComp) || i < len(newComp); i++ {
+		if i < len(oldComp) && i < len(newComp) && oldComp[i] == newComp[i] {
+			continue
+		}
+		switch i {
+		case 0:
+			return api.ChangeMajor, downgrade
+		case 1:
+			return api.ChangeMinor, downgrade
+		}
+		return api.ChangePatch, downgrade
+	}
+	// Something after the numeric components changed
+	if s.cmp(oldV, newV) != 0 {
+		return "", downgrade
+	}
+	if oldRel != newRel {
+		return api.ChangeRelease, downgrade
+	}
+	return "", downgrade
+}
+
+// versionEpoch returns the epoch of [<epoch>:]<version>, empty if there is none or it is zero
+func versionEpoch(v string) string {
+	i := strings.Index(v, ":")
+	if i < 1 || !isNumeric(v[:i]) {
+		return ""
+	}
+	return strings.TrimLeft(v[:i], "0")
+}
+
+// classifyUpdate sets the epoch, change level and downgrade flag of u according to the version scheme of its backend
+func classifyUpdate(u *api.Update) {
+	s, ok := versionSchemes[u.Backend]
+	if !ok {
+		return
+	}
+	if u.Epoch == "" {
+		u.Epoch = versionEpoch(u.NewVer)
+	}
+	if u.OldVer == "" || u.NewVer == "" {
+		return
+	}
+	u.Change, u.Downgrade = s.changeLevel(u.OldVer, u.NewVer)
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/cosandr/go-check-updates/api"
+)
+
+func TestVercmpAlpm(t *testing.T) {
+	// Cases based on pacman's test/util/vercmptest.sh
+	for _, c := range []struct {
+		a, b     string
+		expected int
+	}{
+		{"1.5.0", "1.5.0", 0},
+		{"1.5.1", "1.5.0", 1},
+		{"1.5.1", "1.5", 1},
+		{"1.5.0", "1.5", 1},
+		{"1.0", "1.0.a", -1},
+		{"1.0.a", "1.0.b", -1},
+		{"1.0a", "1.0", -1},
+		{"1.0a", "1.0b", -1},
+		{"1.0pre", "1.0", -1},
+		{"1.0alpha", "1.0beta", -1},
+		{"1.0", "1.0.1", -1},
+		{"1.0.1", "1.0b", 1},
+		{"1.5.b", "1.5", 1},
+		{"1.5.b-1", "1.5.b", 0},
+		{"1.5-1", "1.5-2", -1},
+		{"1.5-2", "1.5-1", 1},
+		{"1.5-1", "1.5", 0},
+		{"1.5..1", "1.5.1", 1},
+		{"1.5_1", "1.5.1", 0},
+		{"1.5.1", "1.5", 1},
+		{"1:1.0", "2.0", 1},
+		{"0:1.0", "1.0", 0},
+		{"1:1.0", "1:1.1", -1},
+		{"1.1.1.h-1", "1.1.1.i-1", -1},
+		{"20200101", "2020.01.01", 1},
+		{"5.4.7.arch1-1", "5.4.6.arch3-1", 1},
+		{"1.001", "1.1", 0},
+	} {
+		if actual := vercmpAlpm(c.a, c.b); actual != c.expected {
+			t.Errorf("vercmpAlpm(%s, %s): expected %d, got %d", c.a, c.b, c.expected, actual)
+		}
+		if actual := vercmpAlpm(c.b, c.a); actual != -c.expected {
+			t.Errorf("vercmpAlpm(%s, %s): expected %d, got %d", c.b, c.a, -c.expected, actual)
+		}
+	}
+}
+
+func TestVercmpRpm(t *testing.T) {
+	// Cases based on rpm's tests/rpmvercmp.at
+	for _, c := range []struct {
+		a, b     string
+		expected int
+	}{
+		{"1.0", "1.0", 0},
+		{"1.0", "2.0", -1},
+		{"2.0.1", "2.0", 1},
+		{"2.0.1a", "2.0.1", 1},
+		{"5.5p1", "5.5p2", -1},
+		{"5.5p10", "5.5p1", 1},
+		{"10xyz", "10.1xyz", -1},
+		{"xyz10", "xyz10.1", -1},
+		{"xyz.4", "8", -1},
+		{"5.5p1", "5.5.p1", 0},
+		{"10b2", "10a1", 1},
+		{"1b.fc17", "1b.fc17", 0},
+		{"1.0~rc1", "1.0", -1},
+		{"1.0~rc1", "1.0~rc2", -1},
+		{"1.0~rc1~git123", "1.0~rc1", -1},
+		{"1.0^", "1.0", 1},
+		{"1.0^git1", "1.0", 1},
+		{"1.0^git1", "1.01", -1},
+		{"1.0^git1", "1.0^git2", -1},
+		{"1.0^git1~pre", "1.0^git1", -1},
+		{"1.0~rc1^git1", "1.0~rc1", 1},
+		{"1:1.0-1.fc33", "2.0-1.fc33", 1},
+		{"1.1.1i-1.fc33", "1.1.1h-1.fc33", 1},
+		{"5.10.6-200.fc33", "5.10.6-100.fc33", 1},
+		{"5.10.6-200.fc33", "5.10.6", 0},
+	} {
+		if actual := vercmpRpm(c.a, c.b); actual != c.expected {
+			t.Errorf("vercmpRpm(%s, %s): expected %d, got %d", c.a, c.b, c.expected, actual)
+		}
+		if actual := vercmpRpm(c.b, c.a); actual != -c.expected {
+			t.Errorf("vercmpRpm(%s, %s): expected %d, got %d", c.b, c.a, -c.expected, actual)
+		}
+	}
+}
+
+func TestVercmpDeb(t *testing.T) {
+	for _, c := range []struct {
+		a, b     string
+		expected int
+	}{
+		{"1.0", "1.0", 0},
+		{"1.0", "1.0-0", 0},
+		{"1.0-1", "1.0-2", -1},
+		{"1.0~rc1-1", "1.0-1", -1},
+		{"1.0~~", "1.0~", -1},
+		{"1.0~", "1.0", -1},
+		{"1.0a", "1.0", 1},
+		{"1.0a", "1.0.", -1},
+		{"1.0+b1", "1.0", 1},
+		{"1:0.9", "2.0", 1},
+		{"2.31-0ubuntu9.1", "2.31-0ubuntu9", 1},
+		{"1.1.1f-1ubuntu2.1", "1.1.1f-1ubuntu2", 1},
+		{"5.4.0-60.67", "5.4.0-59.65", 1},
+		{"1.001", "1.1", 0},
+		{"2:8.2.0716-3ubuntu2", "2:8.1.2269-1ubuntu5", 1},
+	} {
+		if actual := vercmpDeb(c.a, c.b); actual != c.expected {
+			t.Errorf("vercmpDeb(%s, %s): expected %d, got %d", c.a, c.b, c.expected, actual)
+		}
+		if actual := vercmpDeb(c.b, c.a); actual != -c.expected {
+			t.Errorf("vercmpDeb(%s, %s): expected %d, got %d", c.b, c.a, -c.expected, actual)
+		}
+	}
+}
+
+func TestVercmpSemver(t *testing.T) {
+	// Ordering example from https://semver.org/#spec-item-11
+	for _, c := range []struct {
+		a, b     string
+		expected int
+	}{
+		{"1.0.0", "1.0.0", 0},
+		{"v1.0.0", "1.0.0", 0},
+		{"1.0.0+build.1", "1.0.0", 0},
+		{"1.0.0-alpha", "1.0.0-alpha.1", -1},
+		{"1.0.0-alpha.1", "1.0.0-alpha.beta", -1},
+		{"1.0.0-alpha.beta", "1.0.0-beta", -1},
+		{"1.0.0-beta", "1.0.0-beta.2", -1},
+		{"1.0.0-beta.2", "1.0.0-beta.11", -1},
+		{"1.0.0-beta.11", "1.0.0-rc.1", -1},
+		{"1.0.0-rc.1", "1.0.0", -1},
+		{"1.9.0", "1.10.0", -1},
+		{"6.14.8", "6.14.10", -1},
+		{"4.2.0-beta", "4.1.3", 1},
+		{"1.51.0-nightly", "1.51.0-nightly", 0},
+	} {
+		if actual := vercmpSemver(c.a, c.b); actual != c.expected {
+			t.Errorf("vercmpSemver(%s, %s): expected %d, got %d", c.a, c.b, c.expected, actual)
+		}
+		if actual := vercmpSemver(c.b, c.a); actual != -c.expected {
+			t.Errorf("vercmpSemver(%s, %s): expected %d, got %d", c.b, c.a, -c.expected, actual)
+		}
+	}
+}
+
+func TestVercmpPep440(t *testing.T) {
+	// Ordering examples from PEP 440
+	for _, c := range []struct {
+		a, b     string
+		expected int
+	}{
+		{"1.0", "1.0.0", 0},
+		{"1.0.dev456", "1.0a1", -1},
+		{"1.0a1", "1.0a2.dev456", -1},
+		{"1.0a2.dev456", "1.0a12.dev456", -1},
+		{"1.0a12.dev456", "1.0a12", -1},
+		{"1.0a12", "1.0b1.dev456", -1},
+		{"1.0b1.dev456", "1.0b2", -1},
+		{"1.0b2", "1.0b2.post345.dev456", -1},
+		{"1.0b2.post345.dev456", "1.0b2.post345", -1},
+		{"1.0b2.post345", "1.0rc1.dev456", -1},
+		{"1.0rc1.dev456", "1.0rc1", -1},
+		{"1.0rc1", "1.0", -1},
+		{"1.0", "1.0+abc.5", 0},
+		{"1.0", "1.0.post456.dev34", -1},
+		{"1.0.post456.dev34", "1.0.post456", -1},
+		{"1.0.post456", "1.1.dev1", -1},
+		{"1.0-1", "1.0.post1", 0},
+		{"1!1.0", "2.0", 1},
+		{"20.8b1", "20.8", -1},
+		{"1.1.2", "1.1.10", -1},
+	} {
+		if actual := vercmpPep440(c.a, c.b); actual != c.expected {
+			t.Errorf("vercmpPep440(%s, %s): expected %d, got %d", c.a, c.b, c.expected, actual)
+		}
+		if actual := vercmpPep440(c.b, c.a); actual != -c.expected {
+			t.Errorf("vercmpPep440(%s, %s): expected %d, got %d", c.b, c.a, -c.expected, actual)
+		}
+	}
+}
+
+func TestClassifyUpdate(t *testing.T) {
+	for _, c := range []struct {
+		u         api.Update
+		change    string
+		downgrade bool
+	}{
+		{api.Update{Pkg: "linux", OldVer: "5.9.14.arch1-1", NewVer: "5.10.4.arch2-1", Backend: "pacman"}, api.ChangeMinor, false},
+		{api.Update{Pkg: "bash", OldVer: "5.0.018-1", NewVer: "5.0.018-2", Backend: "pacman"}, api.ChangeRelease, false},
+		{api.Update{Pkg: "python", OldVer: "3.8.6-1", NewVer: "3.9.1-1", Backend: "pacman"}, api.ChangeMinor, false},
+		{api.Update{Pkg: "gnupg", OldVer: "2.2.25-1", NewVer: "2.2.26-1", Backend: "pacman"}, api.ChangePatch, false},
+		{api.Update{Pkg: "firefox", OldVer: "83.0-1", NewVer: "84.0-1", Backend: "pacman"}, api.ChangeMajor, false},
+		{api.Update{Pkg: "firefox", OldVer: "84.0-1", NewVer: "83.0-1", Backend: "pacman"}, api.ChangeMajor, true},
+		{api.Update{Pkg: "vim", OldVer: "8.2.1989-1", NewVer: "1:8.2.1989-1", Backend: "pacman"}, api.ChangeMajor, false},
+		{api.Update{Pkg: "openssl", OldVer: "1:1.1.1h-1.fc33", NewVer: "1:1.1.1i-1.fc33", Backend: "dnf"}, api.ChangePatch, false},
+		{api.Update{Pkg: "kernel", OldVer: "5.9.16-200.fc33", NewVer: "5.10.6-200.fc33", Backend: "dnf"}, api.ChangeMinor, false},
+		{api.Update{Pkg: "libc6", OldVer: "2.31-0ubuntu9", NewVer: "2.31-0ubuntu9.1", Backend: "apt"}, api.ChangeRelease, false},
+		{api.Update{Pkg: "openssl", OldVer: "1.1.1f-1ubuntu2.1", NewVer: "1.1.1f-1ubuntu2", Backend: "apt"}, api.ChangeRelease, true},
+		{api.Update{Pkg: "vim-tiny", OldVer: "2:8.1.2269-1ubuntu4", NewVer: "2:8.1.2269-1ubuntu5", Backend: "apt"}, api.ChangeRelease, false},
+		// Debian native versions and ones without leading numbers aren't classified
+		{api.Update{Pkg: "base-files", OldVer: "11ubuntu5.1", NewVer: "11ubuntu5.2", Backend: "apt"}, "", false},
+		{api.Update{Pkg: "tzdata", OldVer: "2020a-0ubuntu0.20.04", NewVer: "2020d-0ubuntu0.20.04", Backend: "apt"}, "", false},
+		{api.Update{Pkg: "git", OldVer: "2.29.2.r1.gabc-1", NewVer: "2.29.2.r2.gdef-1", Backend: "pacman"}, "", false},
+		// Version schemes which aren't implemented
+		{api.Update{Pkg: "musl", OldVer: "1.2.1-r1", NewVer: "1.2.2-r0", Backend: "apk"}, "", false},
+		{api.Update{Pkg: "sys-apps/portage", OldVer: "3.0.12_p1", NewVer: "3.0.12", Backend: "emerge"}, "", false},
+		{api.Update{Pkg: "xbps", OldVer: "0.59.1_5", NewVer: "0.59.1_6", Backend: "xbps"}, "", false},
+		{api.Update{Pkg: "typescript", OldVer: "4.1.0-rc.1", NewVer: "4.1.0", Backend: "npm"}, "", false},
+		{api.Update{Pkg: "docker.io/library/nginx:1.19", OldVer: "4cf620a5c813", NewVer: "0b1c2d3e4f5a", Backend: "docker"}, "", false},
+		{api.Update{Pkg: "openSUSE-2020-2345", NewVer: "1", Backend: "zypper"}, "", false},
+	} {
+		u := c.u
+		classifyUpdate(&u)
+		if u.Change != c.change || u.Downgrade != c.downgrade {
+			t.Errorf("%s %s -> %s: expected %s (downgrade %v), got %s (downgrade %v)",
+				u.Pkg, u.OldVer, u.NewVer, c.change, c.downgrade, u.Change, u.Downgrade)
+		}
+	}
+	for v, expected := range map[string]string{"1:8.2.1989-1": "1", "0:8.2.1989-1": "", "8.2.1989-1": "", "a:1": ""} {
+		u := api.Update{Pkg: "vim", NewVer: v, Backend: "pacman"}
+		if classifyUpdate(&u); u.Epoch != expected {
+			t.Errorf("%s: expected epoch '%s', got '%s'", v, expected, u.Epoch)
+		}
+	}
+	updates := api.UpdatesList{
+		{Pkg: "firefox", Change: api.ChangeMajor},
+		{Pkg: "bash", Change: api.ChangeRelease},
+		{Pkg: "python", Change: api.ChangeMinor, Downgrade: true},
+	}
+	if actual := updates.FilterChange([]string{api.ChangeMajor, api.ChangeMinor}); len(actual) != 2 || actual[1].Pkg != "python" {
+		t.Errorf("expected firefox and python, got %v", actual)
+	}
+	if actual := updates.Downgrades(); len(actual) != 1 || actual[0].Pkg != "python" {
+		t.Errorf("expected python, got %v", actual)
+	}
+}
@@ -0,0 +1,308 @@
+package main
+
+/*
+$ xbps-install -Mnu
+xbps-0.59.1_5 update x86_64 https://alpha.de.repo.voidlinux.org/current 1720320 461556
+libcurl-7.74.0_1 update x86_64 https://alpha.de.repo.voidlinux.org/current 802816 343656
+
+$ xbps-query -l
+ii xbps-0.59.1_4      XBPS package system utilities
+ii libcurl-7.73.0_1   Multiprotocol file transfer library
+
+$ grep -A1 installed_size /var/db/xbps/pkgdb-0.38.plist
+		<key>installed_size</key>
+		<integer>1703936</integer>
+
+$ cat /var/log/socklog/xbps/current (with syslog enabled in xbps.conf)
+2020-12-05T10:21:33.12345 user.notice: xbps-install: Updated `xbps-0.59.1_5' (from: 0.59.1_4) successfully (rootdir: /)
+2020-12-05T10:21:34.12345 user.notice: xbps-remove: Removed `foo-1.0_1' successfully (rootdir: /)
+*/
+
+import (
+	"bufio"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cosandr/go-check-updates/api"
+)
+
+const svlogdTimeFmt = "2006-01-02T15:04:05"
+
+// Group 1: <name>-<new version>
+// Group 2: action (update, install, etc.)
+// Group 3: arch
+// Group 4: repository
+// Group 5: installed size (optional)
+// Group 6: download size (optional)
+var reXbpsInstall = regexp.MustCompile(`(?m)^(\S+)\s+(\w+)\s+(\S+)\s+(\S+)(?:\s+(\d+))?(?:\s+(\d+))?\s*$`)
+
+// Group 1: svlogd timestamp (optional)
+// Group 2: action (Installed, Updated, Removed)
+// Group 3: <name>-<version>
+var reXbpsLog = regexp.MustCompile("^(?:(\\d{4}-\\d{2}-\\d{2}[T_]\\d{2}:\\d{2}:\\d{2})\\S*\\s)?.*(Installed|Updated|Removed) `(\\S+)'")
+
+// xbpsBackend checks for updates using xbps-install, repository index is synced in memory
+type xbpsBackend struct {
+	logFp   string
+	pkgdbFp string
+}
+
+func init() {
+	registerBackend(&xbpsBackend{
+		logFp:   "/var/log/socklog/xbps/current",
+		pkgdbFp: "/var/db/xbps/pkgdb-0.38.plist",
+	})
+}
+
+func (b *xbpsBackend) Name() string { return "xbps" }
+
+func (b *xbpsBackend) Detect(ctx context.Context, distro string) bool { return distro == "void" }
+
+func (b *xbpsBackend) Check(ctx context.Context) (api.UpdatesList, error) {
+	return UpdateXbps(ctx, b.pkgdbFp)
+}
+
+// LogPaths returns the socklog xbps log and the package database, if present
+func (b *xbpsBackend) LogPaths() []string {
+	ret := make([]string, 0)
+	for _, fp := range []string{b.logFp, b.pkgdbFp} {
+		if fp != "" && checkFileExists(fp) {
+			ret = append(ret, fp)
+		}
+	}
+	return ret
+}
+
+func (b *xbpsBackend) ParseLog(ctx context.Context, fp string, f *api.File) error {
+	if fp == b.pkgdbFp {
+		raw, err := runCmd(ctx, "xbps-query", "-l")
+		if err != nil {
+			return err
+		}
+		checkXbpsInstalled(parseXbpsQueryList(raw), f)
+		return nil
+	}
+	return checkXbpsLogs(fp, f)
+}
+
+func (b *xbpsBackend) Capabilities() Capability { return CapOldVersion | CapRepo | CapLogs }
+
+// UpdateXbps uses xbps-install to get available updates, xbps-query for installed versions
+// and the package database at pkgdbFp for installed sizes
+func UpdateXbps(ctx context.Context, pkgdbFp string) (api.UpdatesList, error) {
+	raw, err := runCmd(ctx, "xbps-install", "-Mnu")
+	if err != nil {
+		return api.UpdatesList{}, err
+	}
+	updates := parseXbpsInstall(raw)
+	if len(updates) == 0 {
+		return updates, nil
+	}
+	raw, err = runCmd(ctx, "xbps-query", "-l")
+	if err != nil {
+		log.Warnf("UpdateXbps: cannot get installed versions: %v", err)
+		return updates, nil
+	}
+	installed := parseXbpsQueryList(raw)
+	for i, u := range updates {
+		updates[i].OldVer = installed[u.Pkg]
+	}
+	sizes, err := readXbpsPkgdbSizes(pkgdbFp)
+	if err != nil {
+		log.Warnf("UpdateXbps: cannot get installed sizes: %v", err)
+	}
+	setXbpsInstallSizes(updates, sizes)
+	return updates, nil
+}
+
+// readXbpsPkgdbSizes returns a map of installed package name -> installed size from the package database
+func readXbpsPkgdbSizes(fp string) (map[string]int64, error) {
+	file, err := os.Open(fp)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return parseXbpsPkgdbSizes(file)
+}
+
+// parseXbpsPkgdbSizes parses the package database plist, a dictionary of package name -> package dictionary
+func parseXbpsPkgdbSizes(r io.Reader) (map[string]int64, error) {
+	ret := make(map[string]int64)
+	dec := xml.NewDecoder(r)
+	var depth int
+	var key, pkg string
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return ret, nil
+		} else if err != nil {
+			return ret, fmt.Errorf("cannot parse package database: %v", err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "dict":
+				depth++
+				if depth == 2 {
+					pkg = key
+				}
+			case "key":
+				if err := dec.DecodeElement(&key, &t); err != nil {
+					return ret, fmt.Errorf("cannot parse package database: %v", err)
+				}
+			case "integer":
+				var val string
+				if err := dec.DecodeElement(&val, &t); err != nil {
+					return ret, fmt.Errorf("cannot parse package database: %v", err)
+				}
+				if depth == 2 && key == "installed_size" {
+					ret[pkg], _ = strconv.ParseInt(strings.TrimSpace(val), 10, 64)
+				}
+			}
+		case xml.EndElement:
+			if t.Name.Local == "dict" {
+				depth--
+			}
+		}
+	}
+}
+
+// setXbpsInstallSizes turns the installed sizes of updates into the change from the installed version
+//
+// The change is unknown for packages which aren't in sizes.
+func setXbpsInstallSizes(updates api.UpdatesList, sizes map[string]int64) {
+	for i, u := range updates {
+		if old, ok := sizes[u.Pkg]; ok && u.InstallSize > 0 {
+			updates[i].InstallSize = u.InstallSize - old
+		} else {
+			updates[i].InstallSize = 0
+		}
+	}
+}
+
+// splitXbpsPkgver splits <name>-<version>_<revision> into name and version
+func splitXbpsPkgver(pkgver string) (name string, ver string) {
+	i := strings.LastIndex(pkgver, "-")
+	if i < 1 {
+		return pkgver, ""
+	}
+	return pkgver[:i], pkgver[i+1:]
+}
+
+// parseXbpsInstall parses xbps-install -n output, InstallSize is the installed size of the new version
+func parseXbpsInstall(out string) api.UpdatesList {
+	updates := make(api.UpdatesList, 0)
+	for _, m := range reXbpsInstall.FindAllStringSubmatch(out, -1) {
+		if m[2] != "update" {
+			continue
+		}
+		name, ver := splitXbpsPkgver(m[1])
+		install, _ := strconv.ParseInt(m[5], 10, 64)
+		download, _ := strconv.ParseInt(m[6], 10, 64)
+		updates = append(updates, api.Update{
+			Pkg:          name,
+			NewVer:       ver,
+			Repo:         path.Base(m[4]),
+			Arch:         m[3],
+			DownloadSize: download,
+			InstallSize:  install,
+		})
+	}
+	return updates
+}
+
+// parseXbpsQueryList returns a map of installed package name -> version
+func parseXbpsQueryList(out string) map[string]string {
+	ret := make(map[string]string)
+	for _, line := range strings.Split(out, "\n") {
+		cols := strings.Fields(line)
+		if len(cols) < 2 {
+			continue
+		}
+		name, ver := splitXbpsPkgver(cols[1])
+		ret[name] = ver
+	}
+	return ret
+}
+
+// checkXbpsInstalled removes updates which are installed or no longer present
+func checkXbpsInstalled(installed map[string]string, f *api.File) {
+	beforeLen := len(f.Updates)
+	updates := make(api.UpdatesList, 0)
+	for _, u := range f.Updates {
+		ver, ok := installed[u.Pkg]
+		if u.Backend != "" && u.Backend != "xbps" {
+			updates = append(updates, u)
+		} else if !ok {
+			log.Debugf("checkXbpsInstalled: removed uninstalled package %s", u.Pkg)
+		} else if ver == u.NewVer {
+			log.Debugf("checkXbpsInstalled: removed upgraded package %s %s", u.Pkg, ver)
+		} else {
+			updates = append(updates, u)
+		}
+	}
+	f.Updates = updates
+	if len(f.Updates) != beforeLen {
+		log.Infof("checkXbpsInstalled: removed %d pending updates", beforeLen-len(f.Updates))
+	}
+}
+
+// checkXbpsLogs read xbps syslog messages and update f accordingly
+//
+// Lines without an svlogd timestamp (UTC) are matched by version only
+func checkXbpsLogs(fp string, f *api.File) error {
+	file, err := os.Open(fp)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	lastChecked, err := time.Parse(time.RFC3339, f.Checked)
+	if err != nil {
+		return fmt.Errorf("cannot parse cached time '%s': %v", f.Checked, err)
+	}
+	beforeLen := len(f.Updates)
+	for scanner.Scan() {
+		m := reXbpsLog.FindStringSubmatch(scanner.Text())
+		if len(m) != 4 {
+			continue
+		}
+		timestamp, action := m[1], m[2]
+		name, ver := splitXbpsPkgver(m[3])
+		if timestamp != "" {
+			t, err := time.Parse(svlogdTimeFmt, strings.Replace(timestamp, "_", "T", 1))
+			if err != nil {
+				log.Debugf("checkXbpsLogs: cannot parse '%s': %v", timestamp, err)
+				continue
+			}
+			if t.Before(lastChecked) {
+				log.Debugf("checkXbpsLogs: skip '%s', timestamp too early %v", name, t)
+				continue
+			}
+		}
+		switch action {
+		case "Installed", "Updated":
+			if changed := f.Remove(name, ver); changed {
+				log.Debugf("checkXbpsLogs: removed upgraded package %s %s", name, ver)
+			}
+		case "Removed":
+			if changed := f.Remove(name, ""); changed {
+				log.Debugf("checkXbpsLogs: removed uninstalled package %s %s", name, ver)
+			}
+		}
+	}
+	if len(f.Updates) != beforeLen {
+		log.Infof("checkXbpsLogs: %s: removed %d pending updates", fp, beforeLen-len(f.Updates))
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cosandr/go-check-updates/api"
+)
+
+func TestVoidParseXbpsInstall(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+	out := `xbps-0.59.1_5 update x86_64 https://alpha.de.repo.voidlinux.org/current 1720320 461556
+libcurl-7.74.0_1 update x86_64 https://alpha.de.repo.voidlinux.org/current 802816 343656
+libnghttp2-1.42.0_1 install x86_64 https://alpha.de.repo.voidlinux.org/current 294912 115712
+nvidia-455.45.01_1 update x86_64 https://alpha.de.repo.voidlinux.org/current/nonfree 180224000 90456064
+`
+	actual := parseXbpsInstall(out)
+	installed := parseXbpsQueryList(`ii xbps-0.59.1_4                     XBPS package system utilities
+ii libcurl-7.73.0_1                  Multiprotocol file transfer library
+ii nvidia-455.38_1                   NVIDIA drivers for linux (long-lived series)
+ii python3-pip-20.3.1_1              PyPA recommended tool for installing Python packages
+`)
+	for i, u := range actual {
+		actual[i].OldVer = installed[u.Pkg]
+	}
+	expected := api.UpdatesList{
+		{
+			Pkg:    "xbps",
+			OldVer: "0.59.1_4",
+			NewVer: "0.59.1_5",
+			Repo:   "current",
+			Arch:   "x86_64",
+		},
+		{
+			Pkg:    "libcurl",
+			OldVer: "7.73.0_1",
+			NewVer: "7.74.0_1",
+			Repo:   "current",
+			Arch:   "x86_64",
+		},
+		{
+			Pkg:    "nvidia",
+			OldVer: "455.38_1",
+			NewVer: "455.45.01_1",
+			Repo:   "nonfree",
+			Arch:   "x86_64",
+		},
+	}
+	if len(actual) != len(expected) {
+		t.Fatalf("expected %d updates, got %d: %v", len(expected), len(actual), actual)
+	}
+	for i, a := range actual {
+		if e := expected[i]; !a.Equals(&e) {
+			t.Errorf("expected %v, got %v", e, a)
+		}
+	}
+	if actual[2].DownloadSize != 90456064 {
+		t.Errorf("expected nvidia download size 90456064, got %d", actual[2].DownloadSize)
+	}
+	if actual[2].InstallSize != 180224000 {
+		t.Errorf("expected nvidia install size 180224000, got %d", actual[2].InstallSize)
+	}
+	sizes, err := parseXbpsPkgdbSizes(strings.NewReader(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple Computer//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>_XBPS_ALTERNATIVES_</key>
+	<dict>
+		<key>installed_size</key>
+		<integer>1</integer>
+	</dict>
+	<key>libcurl</key>
+	<dict>
+		<key>installed_size</key>
+		<integer>786432</integer>
+		<key>pkgver</key>
+		<string>libcurl-7.73.0_1</string>
+	</dict>
+	<key>xbps</key>
+	<dict>
+		<key>installed_size</key>
+		<integer>1703936</integer>
+		<key>run_depends</key>
+		<array>
+			<string>libcurl&gt;=7.73.0_1</string>
+		</array>
+		<key>pkgver</key>
+		<string>xbps-0.59.1_4</string>
+	</dict>
+</dict>
+</plist>
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	setXbpsInstallSizes(actual, sizes)
+	for i, expected := range []int64{1720320 - 1703936, 802816 - 786432, 0} {
+		if actual[i].InstallSize != expected {
+			t.Errorf("expected %s install size change %d, got %d", actual[i].Pkg, expected, actual[i].InstallSize)
+		}
+	}
+	if ver := installed["python3-pip"]; ver != "20.3.1_1" {
+		t.Errorf("expected python3-pip 20.3.1_1, got %s", ver)
+	}
+}
+
+func TestVoidXbpsLogs(t *testing.T) {
+	log.SetLevel(log.DebugLevel)
+	cache = NewInternalCache()
+	cache.f.Checked = "2020-12-05T10:00:00Z"
+	allUpdates := api.UpdatesList{
+		{
+			Pkg:    "xbps",
+			NewVer: "0.59.1_5",
+		},
+		{
+			Pkg:    "libcurl",
+			NewVer: "7.74.0_1",
+		},
+		{
+			Pkg:    "nvidia",
+			NewVer: "455.45.01_1",
+		},
+	}
+	file := "2020-12-05T10:21:33.12345 user.notice: xbps-install: Updated `xbps-0.59.1_5' (from: 0.59.1_4) successfully (rootdir: /)\n" +
+		"2020-12-05T10:21:33.22345 user.notice: xbps-install: Updated `libcurl-7.73.0_2' (from: 7.73.0_1) successfully (rootdir: /)\n" +
+		"2020-12-05T10:21:34.12345 user.notice: xbps-remove: Removed `nvidia-455.38_1' successfully (rootdir: /)\n" +
+		"2020-12-04_18:00:00.12345 user.notice: xbps-remove: Removed `libcurl-7.70.0_1' successfully (rootdir: /)\n"
+	cache.f.Updates = allUpdates.Copy()
+	fp := "/tmp/xbps_test.log"
+	if err := ioutil.WriteFile(fp, []byte(file), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkXbpsLogs(fp, &cache.f); err != nil {
+		t.Fatal(err)
+	}
+	if len(cache.f.Updates) != 1 || cache.f.Updates[0].Pkg != "libcurl" {
+		t.Errorf("Expected only libcurl, got %d: %v", len(cache.f.Updates), cache.f.Updates)
+	}
+	// Check against installed packages
+	cache.f.Updates = allUpdates.Copy()
+	checkXbpsInstalled(map[string]string{
+		"xbps":    "0.59.1_5",
+		"libcurl": "7.73.0_1",
+	}, &cache.f)
+	if len(cache.f.Updates) != 1 || cache.f.Updates[0].Pkg != "libcurl" {
+		t.Errorf("Expected only libcurl, got %d: %v", len(cache.f.Updates), cache.f.Updates)
+	}
+}
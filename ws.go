@@ -57,6 +57,8 @@ func wsWriter(ctx context.Context, cancel context.CancelFunc, ws *websocket.Conn
 		cancel()
 		wg.Done()
 	}()
+	// Only one goroutine may write to the connection at a time
+	var writeMu sync.Mutex
 	// Ping-Pong goroutine
 	go func() {
 		pingTicker := time.NewTicker(pingPeriod)
@@ -68,8 +70,10 @@ func wsWriter(ctx context.Context, cancel context.CancelFunc, ws *websocket.Conn
 				return
 			case <-pingTicker.C:
 				log.Debugf("wsWriter (%s): sending heartbeat", remoteName)
+				writeMu.Lock()
 				ws.SetWriteDeadline(time.Now().Add(writeWait))
 				err := ws.WriteMessage(websocket.PingMessage, nil)
+				writeMu.Unlock()
 				if err != nil {
 					if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure) {
 						log.Errorf("wsWriter (%s): cannot send heartbeat: %v", remoteName, err)
@@ -88,8 +92,11 @@ func wsWriter(ctx context.Context, cancel context.CancelFunc, ws *websocket.Conn
 			return
 		case <-sub.ch:
 			log.Debugf("wsWriter (%s): sending message", remoteName)
+			f := cache.Snapshot()
+			writeMu.Lock()
 			ws.SetWriteDeadline(time.Now().Add(writeWait))
-			err := ws.WriteJSON(&cache.f)
+			err := ws.WriteJSON(&f)
+			writeMu.Unlock()
 			if err != nil {
 				if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure) {
 					log.Errorf("wsWriter (%s): cannot send message: %v", remoteName, err)
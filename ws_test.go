@@ -54,12 +54,11 @@ func TestWS(t *testing.T) {
 					NewVer: fmt.Sprintf("Iter %d new version %d", i, j),
 				})
 			}
-			cache.f.Updates = updates
-			cache.f.Checked = time.Now().Format(time.RFC3339)
+			setTestFile(cache, updates, time.Now().Format(time.RFC3339))
 			cache.ws.Broadcast()
 			sent[i] = len(updates)
 			log.Infof("set new updates iter %d", i)
-			newDur := time.Duration(rand.Intn(10)) * time.Second
+			newDur := time.Duration(rand.Intn(10)+1) * time.Second
 			log.Infof("ticker duration changed to %.0f seconds", newDur.Seconds())
 			ticker = time.NewTicker(newDur)
 		}